@@ -0,0 +1,66 @@
+package jwtware
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthStage is the stage of the request authentication at which an AuthError occurred.
+type AuthStage string
+
+const (
+	// StageExtraction is the lookup of the token in the request, including its decryption.
+	StageExtraction AuthStage = "extraction"
+	// StageParsing is the decoding of the token and the verification of its signature.
+	StageParsing AuthStage = "parsing"
+	// StageValidation is the validation of the claims and bindings of a correctly signed token.
+	StageValidation AuthStage = "validation"
+)
+
+// AuthError is passed to ErrorHandler when a request fails authentication. It wraps the cause,
+// so errors.Is keeps working with the Err... values, and may be inspected with errors.As.
+type AuthError struct {
+	// Stage is the stage at which the authentication failed.
+	Stage AuthStage
+	// KID is the "kid" header of the token, if it could be decoded.
+	KID string
+	// Alg is the "alg" header of the token, if it could be decoded.
+	Alg string
+	// Err is the cause of the failure.
+	Err error
+}
+
+// Error returns the message of the cause.
+func (e *AuthError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the cause.
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// newAuthError returns an AuthError for the given cause. The headers are read from the token, or from
+// the signed JWT without verifying it if no token is given.
+func newAuthError(stage AuthStage, err error, token *jwt.Token, signed string) *AuthError {
+	authErr := &AuthError{Stage: stage, Err: err}
+	if token == nil && signed != "" {
+		token, _, _ = jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	}
+	if token != nil {
+		authErr.KID, _ = token.Header["kid"].(string)
+		authErr.Alg, _ = token.Header["alg"].(string)
+	}
+	return authErr
+}
+
+// verifyStage returns the stage at which the verification of a token failed with the given error.
+func verifyStage(err error) AuthStage {
+	for _, validation := range []error{jwt.ErrTokenInvalidClaims, ErrJWTType, ErrJWTIssuedInFuture, ErrJWTMissingSubject, jwt.ErrTokenRequiredClaimMissing, jwt.ErrTokenInvalidIssuer, jwt.ErrTokenInvalidAudience, ErrJWTAuthorizedParty} {
+		if errors.Is(err, validation) {
+			return StageValidation
+		}
+	}
+	return StageParsing
+}
@@ -0,0 +1,147 @@
+package jwtware
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultVerificationCacheSize = 1024
+
+// verificationCache remembers successfully verified tokens, so that repeated requests with the same token
+// skip the signature verification. Entries are keyed by the hash of the whole token, never by its signature
+// alone, which would let a forged payload reuse the entry of a valid signature.
+type verificationCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mux     sync.Mutex
+	entries map[[sha256.Size]byte]verificationCacheEntry
+}
+
+type verificationCacheEntry struct {
+	token   *jwt.Token
+	expires time.Time
+}
+
+func newVerificationCache(ttl time.Duration, size int, now func() time.Time) *verificationCache {
+	return &verificationCache{
+		ttl:     ttl,
+		size:    size,
+		now:     now,
+		entries: make(map[[sha256.Size]byte]verificationCacheEntry),
+	}
+}
+
+// get returns a copy of the verified token for the given signed JWT, if it is cached and did not expire.
+// Every request gets its own copy, as handlers may modify the claims, e.g. of jwt.MapClaims.
+func (v *verificationCache) get(signed string) (*jwt.Token, bool) {
+	key := sha256.Sum256([]byte(signed))
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	entry, ok := v.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if v.now().After(entry.expires) {
+		delete(v.entries, key)
+		return nil, false
+	}
+	return cloneToken(entry.token), true
+}
+
+// put caches the verified token for the TTL, but not beyond its "exp" claim. When the cache is full,
+// expired entries are evicted, and the token is not cached if that does not free any space.
+func (v *verificationCache) put(signed string, token *jwt.Token) {
+	now := v.now()
+	expires := now.Add(v.ttl)
+	if exp, err := token.Claims.GetExpirationTime(); err == nil && exp != nil && exp.Before(expires) {
+		expires = exp.Time
+	}
+	key := sha256.Sum256([]byte(signed))
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if len(v.entries) >= v.size {
+		for k, entry := range v.entries {
+			if now.After(entry.expires) {
+				delete(v.entries, k)
+			}
+		}
+		if len(v.entries) >= v.size {
+			return
+		}
+	}
+	v.entries[key] = verificationCacheEntry{token: cloneToken(token), expires: expires}
+}
+
+// cloneToken returns a deep copy of the given token, so that modifying the header or the claims of either does
+// not affect the other. Unexported fields of claims structs are copied shallowly.
+func cloneToken(token *jwt.Token) *jwt.Token {
+	clone := *token
+	clone.Header = deepCopy(reflect.ValueOf(token.Header)).Interface().(map[string]interface{})
+	clone.Signature = append([]byte(nil), token.Signature...)
+	if token.Claims != nil {
+		clone.Claims = deepCopy(reflect.ValueOf(token.Claims)).Interface().(jwt.Claims)
+	}
+	return &clone
+}
+
+// deepCopy returns a deep copy of the given value, following pointers, interfaces, maps, slices, arrays and
+// the exported fields of structs.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Elem().Type())
+		c.Elem().Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
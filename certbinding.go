@@ -0,0 +1,34 @@
+package jwtware
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWTCertBindingMissing is returned when certificate binding is enabled and the request
+	// was not made with a TLS client certificate.
+	ErrJWTCertBindingMissing = newStatusError(fiber.StatusUnauthorized, "the JWT requires a TLS client certificate")
+
+	// ErrJWTCertBindingMismatch is returned when the "cnf.x5t#S256" claim of the JWT does not match
+	// the TLS client certificate.
+	ErrJWTCertBindingMismatch = newStatusError(fiber.StatusUnauthorized, "the JWT is not bound to the TLS client certificate")
+)
+
+// validateCertBinding checks that the "cnf.x5t#S256" claim of the token is the SHA-256 thumbprint
+// of the TLS client certificate of the request (RFC 8705 section 3).
+func validateCertBinding(c *fiber.Ctx, token *jwt.Token) error {
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ErrJWTCertBindingMissing
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	cnf, _ := claimValue(token.Claims, "cnf").(map[string]interface{})
+	if x5t, _ := cnf["x5t#S256"].(string); x5t != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return ErrJWTCertBindingMismatch
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package jwtware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWTClaimMissing is returned by Claim when the token does not have the claim.
+	ErrJWTClaimMissing = errors.New("the JWT claim is missing")
+
+	// ErrJWTClaimType is returned by Claim when the claim cannot be converted to the requested type.
+	ErrJWTClaimType = errors.New("the JWT claim has an unexpected type")
+)
+
+// Claim returns the named claim of the token converted to T. Numeric claims, which are decoded as float64
+// or json.Number, are converted to any integer or float type if they fit. Time claims such as "exp" may be
+// read as time.Time or *jwt.NumericDate, and a single string may be read as []string like the "aud" claim.
+// Claims other than jwt.MapClaims are read through their JSON representation.
+func Claim[T any](token *jwt.Token, name string) (T, error) {
+	var result T
+	if token == nil {
+		return result, fmt.Errorf("%w: %q", ErrJWTClaimMissing, name)
+	}
+	value, ok := claimsMap(token.Claims, json.Unmarshal)[name]
+	if !ok || value == nil {
+		return result, fmt.Errorf("%w: %q", ErrJWTClaimMissing, name)
+	}
+	if typed, ok := value.(T); ok {
+		return typed, nil
+	}
+	if !convertClaim(value, &result) {
+		var zero T
+		return zero, fmt.Errorf("%w: %q is %T, not %T", ErrJWTClaimType, name, value, result)
+	}
+	return result, nil
+}
+
+// MustClaim is like Claim but panics if the claim is missing or has another type, e.g. for claims the
+// configured validation guarantees.
+func MustClaim[T any](token *jwt.Token, name string) T {
+	value, err := Claim[T](token, name)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// NamespacedClaim returns the named claim of the token, looking it up under the given namespace first, e.g.
+// "https://myapp.com/roles" for the namespace "https://myapp.com/" and the name "roles", as Auth0 and Okta
+// require for custom claims, and then under the bare name. A "/" is inserted after the namespace if it does
+// not end with one. It reports whether the claim was found.
+func NamespacedClaim(token *jwt.Token, namespace, name string) (interface{}, bool) {
+	if token == nil {
+		return nil, false
+	}
+	return namespacedValue(claimsMap(token.Claims, json.Unmarshal), namespace, name)
+}
+
+// namespacedValue returns the named claim, preferring its namespaced form.
+func namespacedValue(claims jwt.MapClaims, namespace, name string) (interface{}, bool) {
+	if namespace != "" {
+		if !strings.HasSuffix(namespace, "/") {
+			namespace += "/"
+		}
+		if value, ok := claims[namespace+name]; ok {
+			return value, true
+		}
+	}
+	value, ok := claims[name]
+	return value, ok
+}
+
+// convertClaim converts the decoded claim value into the value target points to, reporting whether it could.
+func convertClaim(value interface{}, target interface{}) bool {
+	switch t := target.(type) {
+	case *time.Time:
+		date, ok := numericDate(value)
+		if ok {
+			*t = date.Time
+		}
+		return ok
+	case **jwt.NumericDate:
+		date, ok := numericDate(value)
+		if ok {
+			*t = date
+		}
+		return ok
+	case *[]string:
+		return convertStrings(value, t)
+	case *float64:
+		f, ok := claimFloat(value)
+		*t = f
+		return ok
+	case *float32:
+		f, ok := claimFloat(value)
+		*t = float32(f)
+		return ok && math.Abs(f) <= math.MaxFloat32
+	case *int:
+		i, ok := claimInt(value, math.MinInt, math.MaxInt)
+		*t = int(i)
+		return ok
+	case *int64:
+		i, ok := claimInt(value, math.MinInt64, math.MaxInt64)
+		*t = i
+		return ok
+	case *int32:
+		i, ok := claimInt(value, math.MinInt32, math.MaxInt32)
+		*t = int32(i)
+		return ok
+	case *uint:
+		i, ok := claimInt(value, 0, math.MaxInt)
+		*t = uint(i)
+		return ok
+	case *uint64:
+		i, ok := claimInt(value, 0, math.MaxInt64)
+		*t = uint64(i)
+		return ok
+	case *uint32:
+		i, ok := claimInt(value, 0, math.MaxUint32)
+		*t = uint32(i)
+		return ok
+	}
+	return false
+}
+
+// claimFloat returns the numeric claim value as float64.
+func claimFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// claimInt returns the numeric claim value as int64 if it is a whole number within the given bounds.
+func claimInt(value interface{}, min, max int64) (int64, bool) {
+	var i int64
+	switch v := value.(type) {
+	case float64:
+		// 2^63 is the first float64 beyond the int64 range; smaller values are checked against the bounds below.
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.Exp2(63) {
+			return 0, false
+		}
+		i = int64(v)
+	case json.Number:
+		var err error
+		if i, err = v.Int64(); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	return i, i >= min && i <= max
+}
+
+// numericDate returns the claim value as a NumericDate, i.e. seconds since the epoch.
+func numericDate(value interface{}) (*jwt.NumericDate, bool) {
+	seconds, ok := claimFloat(value)
+	if !ok {
+		return nil, false
+	}
+	whole, fraction := math.Modf(seconds)
+	return jwt.NewNumericDate(time.Unix(int64(whole), int64(fraction*1e9))), true
+}
+
+// convertStrings converts a single string or an array of strings into a string slice.
+func convertStrings(value interface{}, target *[]string) bool {
+	switch v := value.(type) {
+	case string:
+		*target = []string{v}
+		return true
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return false
+			}
+			strs = append(strs, s)
+		}
+		*target = strs
+		return true
+	}
+	return false
+}
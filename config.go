@@ -1,76 +1,408 @@
 package jwtware
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/MicahParks/keyfunc/v2"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 var (
 	// ErrJWTAlg is returned when the JWT header did not contain the expected algorithm.
-	ErrJWTAlg = errors.New("the JWT header did not contain the expected algorithm")
+	ErrJWTAlg = newStatusError(fiber.StatusUnauthorized, "the JWT header did not contain the expected algorithm")
+
+	// ErrJWTIssuedInFuture is returned when the JWT "iat" claim is in the future.
+	ErrJWTIssuedInFuture = newStatusError(fiber.StatusUnauthorized, "the JWT was issued in the future")
+
+	// ErrJWTNotYetValid is returned when the JWT "nbf" claim is later than now plus Leeway.
+	// The returned error also wraps jwt.ErrTokenNotValidYet.
+	ErrJWTNotYetValid = newStatusError(fiber.StatusUnauthorized, "the JWT is not valid yet")
+
+	// ErrJWTJKUNotAllowed is returned when the JWT "jku" header points to a host that is not allowed.
+	ErrJWTJKUNotAllowed = newStatusError(fiber.StatusUnauthorized, "the JWT \"jku\" header points to a host that is not allowed")
+
+	// ErrJWTType is returned when the JWT "typ" header does not match the expected token type.
+	ErrJWTType = newStatusError(fiber.StatusUnauthorized, "the JWT header did not contain the expected type")
+
+	// ErrJWTTooManyFailures is returned when the FailureLimiter rejects a request.
+	ErrJWTTooManyFailures = newStatusError(fiber.StatusTooManyRequests, "too many failed JWT verifications")
+
+	// ErrJWTMissingSubject is returned when RequireSubject is set and the JWT "sub" claim is missing or empty.
+	ErrJWTMissingSubject = newStatusError(fiber.StatusForbidden, "the JWT \"sub\" claim is missing or empty")
+
+	// ErrJWTAlgNone is returned when the JWT header contains the "none" algorithm and it was not explicitly allowed.
+	ErrJWTAlgNone = newStatusError(fiber.StatusUnauthorized, "the JWT header contained the \"none\" algorithm")
+
+	// ErrJWTKID is returned when KIDValidator rejects the JWT "kid" header. The returned error also wraps the
+	// error of KIDValidator.
+	ErrJWTKID = newStatusError(fiber.StatusUnauthorized, "the JWT \"kid\" header is not allowed")
 )
 
+// Logger is used by the middleware to report errors that occur outside of a request,
+// such as failed background refreshes of JWK Sets. *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// FailureLimiter limits repeated failed verifications, e.g. to slow down brute-forcing of HMAC secrets.
+// Implementations may keep their state in memory or in a shared store such as Redis.
+type FailureLimiter interface {
+	// Allow reports whether a request with the given key may attempt verification.
+	Allow(key string) bool
+	// Failure records a failed verification for the given key.
+	Failure(key string)
+}
+
 // Config defines the config for JWT middleware
 type Config struct {
 	// Filter defines a function to skip middleware.
 	// Optional. Default: nil
 	Filter func(*fiber.Ctx) bool
 
+	// Optional lets requests without a token continue the chain unauthenticated, without calling
+	// SuccessHandler, while requests with an invalid token are still rejected. This suits a GraphQL endpoint,
+	// which is a single POST route serving public and protected operations alike: mount the middleware with
+	// Optional on that route, and let the resolvers enforce per-field authorization with ClaimsFromContext,
+	// treating a missing token as an anonymous caller.
+	// Optional. Default: false
+	Optional bool
+
+	// TrustFunc defines a function to skip token verification for trusted requests, e.g. requests
+	// from an internal network where the caller was already authenticated by other means.
+	// When it returns true, a token, if present, is parsed WITHOUT verifying its signature or claims
+	// and stored into context, and SuccessHandler is always called.
+	//
+	// SECURITY: claims of such tokens can be forged by anyone able to reach the trusted path.
+	// Only use this when TrustFunc can reliably tell trusted requests apart, never based on
+	// client supplied headers alone.
+	// Optional. Default: nil
+	TrustFunc func(*fiber.Ctx) bool
+
+	// TrustedGatewayHeader is the name of a header an upstream API gateway sets to TrustedGatewaySecret
+	// after verifying the token itself. Requests carrying the header with the secret are handled as if
+	// TrustFunc returned true: the token is parsed WITHOUT verifying its signature or claims.
+	//
+	// SECURITY: this is unsafe if requests can reach the application without passing the gateway,
+	// as anyone knowing the secret can then forge claims. The gateway must strip the header from client requests.
+	// Optional. Default: ""
+	TrustedGatewayHeader string
+
+	// TrustedGatewaySecret is the value of TrustedGatewayHeader set by the gateway. It is required with
+	// TrustedGatewayHeader and compared in constant time.
+	// Optional. Default: ""
+	TrustedGatewaySecret string
+
 	// SuccessHandler defines a function which is executed for a valid token.
 	// Optional. Default: nil
 	SuccessHandler fiber.Handler
 
+	// ContinueOnSuccess continues the chain after a custom SuccessHandler returned nil, so that it does not
+	// need to call c.Next itself. The chain is not continued if the SuccessHandler called c.Next into the
+	// handlers of another route, or if it changed the status or the body of the response, e.g. by sending a
+	// response. A SuccessHandler calling c.Next for handlers of the same route which leave the response
+	// untouched should set this to false.
+	// Optional. Default: true
+	ContinueOnSuccess *bool
+
+	// BeforeNext defines a function which is executed for a valid token, after it was stored into
+	// context and before SuccessHandler. Unlike SuccessHandler, it does not need to continue the chain.
+	// A returned error is passed to ErrorHandler.
+	// Optional. Default: nil
+	BeforeNext func(c *fiber.Ctx, token *jwt.Token) error
+
+	// OnExpired defines a function which is executed for a token whose only validation failure is
+	// its expiry, after its signature was verified. Returning nil accepts the expired token and
+	// continues as for a valid one, returning an error passes that error to ErrorHandler.
+	// Optional. Default: nil
+	OnExpired func(c *fiber.Ctx, token *jwt.Token) error
+
 	// ErrorHandler defines a function which is executed for an invalid token.
-	// It may be used to define a custom JWT error.
-	// Optional. Default: 401 Invalid or expired JWT
+	// It may be used to define a custom JWT error. Failures of the token are passed as *AuthError,
+	// which tells the failed stage and the "kid" and "alg" headers of the token.
+	// Optional. Default: 401 Invalid or expired JWT, or 403 for a valid token meant for another audience or
+	// missing a required claim, as JSON if the client prefers it.
 	ErrorHandler fiber.ErrorHandler
 
+	// MissingTokenResponse replaces the response of the default ErrorHandler when no token was found or it
+	// could not be extracted, i.e. for ErrJWTMissingOrMalformed, e.g. to change the status or message.
+	// It is ignored if ErrorHandler is set.
+	// Optional. Default: nil
+	MissingTokenResponse fiber.Handler
+
+	// InvalidTokenResponse replaces the response of the default ErrorHandler for all other failures of the
+	// token, e.g. a bad signature, an expired token or a failed claim validation. Requests rejected by the
+	// FailureLimiter still receive the default response. It is ignored if ErrorHandler is set.
+	// Optional. Default: nil
+	InvalidTokenResponse fiber.Handler
+
+	// FailureLimiter is consulted before each verification and informed about failed verifications of
+	// present tokens. Requests it does not allow are passed to ErrorHandler with ErrJWTTooManyFailures,
+	// which the default ErrorHandler answers with 429 Too Many Requests.
+	// Optional. Default: nil
+	FailureLimiter FailureLimiter
+
+	// ReplayCache makes tokens single-use, e.g. magic links encoded as JWTs: the "jti" of every accepted token
+	// is recorded until the token expires, and tokens whose "jti" was already used are rejected with
+	// ErrJWTReplayed. Tokens without "jti" or "exp" are rejected with an error wrapping
+	// jwt.ErrTokenRequiredClaimMissing. A token is used exactly once even under concurrent requests, and
+	// across instances sharing the cache, as long as ReplayCache.Add checks and records atomically. The "jti"
+	// is only recorded once all other checks, AdditionalTokens and BeforeNext passed, so that a rejected
+	// request does not consume the token, and stays used even if a later handler fails.
+	// Optional. Default: nil
+	ReplayCache ReplayCache
+
+	// FailureLimiterKey defines a function to derive the FailureLimiter key from the request.
+	// Optional. Default: the client IP
+	FailureLimiterKey func(*fiber.Ctx) string
+
 	// Signing key to validate token. Used as fallback if SigningKeys has length 0.
-	// At least one of the following is required: KeyFunc, JWKSetURLs, SigningKeys, or SigningKey.
-	// The order of precedence is: KeyFunc, JWKSetURLs, SigningKeys, SigningKey.
+	// At least one of the following is required: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, or SigningKey.
+	// The order of precedence is: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, SigningKey.
 	SigningKey SigningKey
 
 	// Map of signing keys to validate token with kid field usage.
-	// At least one of the following is required: KeyFunc, JWKSetURLs, SigningKeys, or SigningKey.
-	// The order of precedence is: KeyFunc, JWKSetURLs, SigningKeys, SigningKey.
+	// At least one of the following is required: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, or SigningKey.
+	// The order of precedence is: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, SigningKey.
 	SigningKeys map[string]SigningKey
 
+	// DecryptionKey is the key used to decrypt encrypted JWTs (JWE) whose plaintext is a signed JWT.
+	// Tokens with five segments are treated as JWE and decrypted before the inner JWT is verified.
+	// For supported types, please see https://github.com/go-jose/go-jose.
+	// Optional. Default: nil, encrypted JWTs are rejected with ErrJWEDecryptionKey.
+	DecryptionKey interface{}
+
+	// DecryptionAlgorithms lists the key management algorithms ("alg") accepted for encrypted JWTs. Tokens
+	// using others are rejected with ErrJWEAlgorithm before they are decrypted, e.g. RSA1_5, which is open to
+	// padding oracle attacks, or PBES2, whose iteration count is chosen by the sender.
+	// Optional. Default: RSA-OAEP, RSA-OAEP-256, ECDH-ES, ECDH-ES+A128KW, ECDH-ES+A192KW, ECDH-ES+A256KW, dir
+	DecryptionAlgorithms []string
+
+	// DecryptionEncryptions lists the content encryption algorithms ("enc") accepted for encrypted JWTs.
+	// Tokens using others are rejected with ErrJWEAlgorithm before they are decrypted.
+	// Optional. Default: A128GCM, A192GCM, A256GCM
+	DecryptionEncryptions []string
+
 	// Context key to store user information from the token into context.
 	// Optional. Default: "user".
 	ContextKey string
 
+	// ContextKeyFunc defines a function to derive the context key for the token from the token itself,
+	// e.g. "user:" + tenant. When set, it overrides ContextKey when storing the token into context.
+	// Optional. Default: nil
+	ContextKeyFunc func(token *jwt.Token) string
+
+	// Context key to store the raw, compact token string into context.
+	// Optional. Default: ContextKey + "_raw".
+	RawTokenContextKey string
+
+	// ClaimsToLocals maps claim names to context keys. After a successful verification, the value of each
+	// listed claim is stored into context under the given key, e.g. {"sub": "userID"}. Missing claims are skipped.
+	// Optional. Default: nil
+	ClaimsToLocals map[string]string
+
+	// ClaimsNamespace is the namespace of custom claims, e.g. "https://myapp.com/" for Auth0 or Okta. When set,
+	// ClaimsToLocals looks up each claim under the namespace first and then under its bare name, so that
+	// {"roles": "roles"} maps the "https://myapp.com/roles" claim. See NamespacedClaim.
+	// Optional. Default: ""
+	ClaimsNamespace string
+
+	// EmitExpiresInHeader sets the X-Token-Expires-In response header to the remaining lifetime of a token
+	// with an "exp" claim in seconds, e.g. for clients to schedule a refresh. The lifetime is always stored
+	// into context under ExpiresInContextKey.
+	// Optional. Default: false
+	EmitExpiresInHeader bool
+
+	// PropagateSubjectHeader is the name of a header, e.g. "X-Authenticated-Subject", set to the "sub" claim of
+	// an authenticated token on the request, so that later handlers and upstream services behind a proxy see
+	// it, and on the response, e.g. for tracing. Control characters are removed from the value to prevent
+	// header injection. A header of this name sent by the client is removed if the token has no subject.
+	// Optional. Default: ""
+	PropagateSubjectHeader string
+
 	// Claims are extendable claims data defining token content.
 	// Optional. Default value jwt.MapClaims
 	Claims jwt.Claims
 
+	// Leeway is the allowed clock skew when validating time based claims such as "exp", "nbf" and "iat".
+	// Optional. Default: 0
+	Leeway time.Duration
+
+	// MaxNestingDepth is the maximum number of nested JWTs, announced by the "cty" header "JWT", within a token.
+	// Each nested token is verified with this configuration. A negative value rejects nested tokens.
+	// Optional. Default: 1
+	MaxNestingDepth int
+
+	// TimeFunc returns the current time for the validation of time based claims such as "exp", "nbf" and "iat",
+	// e.g. to inject a fixed clock in tests.
+	// Optional. Default: time.Now
+	TimeFunc func() time.Time
+
+	// ParserOptions are passed to the JWT parser, e.g. jwt.WithIssuer, jwt.WithAudience or
+	// jwt.WithIssuedAt. They are applied after the options derived from other fields such as Leeway.
+	// Optional. Default: nil
+	ParserOptions []jwt.ParserOption
+
+	// RejectFutureIssued rejects tokens whose "iat" claim is later than now plus Leeway
+	// with ErrJWTIssuedInFuture. Tokens without an "iat" claim are not affected.
+	// Optional. Default: false
+	RejectFutureIssued bool
+
+	// RequireExpiration rejects tokens without an "exp" claim, which would otherwise never expire,
+	// with jwt.ErrTokenRequiredClaimMissing.
+	// Optional. Default: false
+	RequireExpiration bool
+
+	// RequireSubject rejects tokens with a missing or empty "sub" claim, such as service tokens,
+	// with ErrJWTMissingSubject.
+	// Optional. Default: false
+	RequireSubject bool
+
+	// ExpectedTokenType is the expected value of the "typ" header, e.g. "at+jwt" for access tokens following
+	// RFC 9068. Tokens with another or no type are rejected with ErrJWTType.
+	// Optional. Default: "", the type is not checked.
+	ExpectedTokenType string
+
+	// ExpectedAudience requires the "aud" claim to contain the given audience. Like all claim validations,
+	// it applies regardless of the key source, including a custom KeyFunc.
+	// Optional. Default: "", the audience is not checked.
+	ExpectedAudience string
+
+	// ExpectedAuthorizedParty requires the "azp" claim of OpenID Connect ID tokens, the client the token was
+	// issued to, to be the given client ID if it is present. Tokens for another client are rejected with
+	// ErrJWTAuthorizedParty.
+	// Optional. Default: "", the authorized party is not checked.
+	ExpectedAuthorizedParty string
+
+	// RequireAuthorizedPartyForMultipleAudiences requires the "azp" claim of tokens whose "aud" claim has more
+	// than one value, as OpenID Connect Core 1.0 section 2 recommends. Such tokens without it are rejected with
+	// an error wrapping jwt.ErrTokenRequiredClaimMissing.
+	// Optional. Default: false
+	RequireAuthorizedPartyForMultipleAudiences bool
+
+	// ExpectedIssuers requires the "iss" claim to be one of the given issuers, e.g. for providers using
+	// several spellings of their issuer. Tokens with another or no issuer are rejected with an error
+	// wrapping jwt.ErrTokenInvalidIssuer.
+	// Optional. Default: nil, the issuer is not checked.
+	ExpectedIssuers []string
+
+	// IssuerPolicies holds validation rules per issuer, e.g. for a gateway in front of several identity
+	// providers with distinct audiences and algorithms. The policy of the "iss" claim of each token is
+	// applied after its signature was verified, and tokens of issuers without a policy are rejected with an
+	// error wrapping jwt.ErrTokenInvalidIssuer. Policies without SigningKeys or JWKSetURLs share the key
+	// sources of the configuration, so any trusted key may sign tokens claiming their issuer.
+	// Optional. Default: nil, no policies are applied.
+	IssuerPolicies map[string]IssuerPolicy
+
+	// ScopeClaim is the name of the claim holding the granted scopes, read by Middleware.RequireScopes.
+	// Providers differ, e.g. "scope", "scp", "roles" or "permissions". The claim may either be a delimited
+	// string or an array of strings.
+	// Optional. Default: "scope"
+	ScopeClaim string
+
+	// ScopeDelimiter separates the scopes if the ScopeClaim is a string. Every character is a delimiter.
+	// Optional. Default: " "
+	ScopeDelimiter string
+
+	// DPoP requires every token to be bound to the key of a DPoP proof (RFC 9449) sent in the "DPoP" header.
+	// The proof must be signed with the public key in its "jwk" header, match the method and URL of the request
+	// as well as the token, and its "jti" must not be reused. The thumbprint of its key must equal the "cnf.jkt"
+	// claim of the token. Failures are reported with ErrDPoPProofMissing, ErrDPoPProofInvalid,
+	// ErrDPoPProofReplayed or ErrDPoPBindingMismatch. AuthScheme defaults to "DPoP".
+	// Optional. Default: false
+	DPoP bool
+
+	// ValidateCertBinding requires every token to be bound to the TLS client certificate of the request
+	// (RFC 8705): its "cnf.x5t#S256" claim must equal the SHA-256 thumbprint of the certificate.
+	// Requests without a client certificate are rejected with ErrJWTCertBindingMissing, others with
+	// ErrJWTCertBindingMismatch.
+	// Optional. Default: false
+	ValidateCertBinding bool
+
+	// ValidateClientIP requires every token to be bound to the IP of the client: its "cnf.ip" claim must
+	// equal c.IP(), which honors the ProxyHeader of the Fiber app. Tokens without a valid "cnf.ip" claim are
+	// rejected with ErrJWTClientIPMissing, others with ErrJWTClientIPMismatch.
+	//
+	// Client IPs are not stable: clients behind NAT share one, and mobile clients change theirs when
+	// switching networks, so tokens must be short-lived or easy to renew. Behind a proxy, ProxyHeader must be
+	// set on the Fiber app, otherwise the IP of the proxy is compared.
+	//
+	// Optional. Default: false
+	ValidateClientIP bool
+
 	// TokenLookup is a string in the form of "<source>:<name>" that is used
 	// to extract token from the request.
 	// Optional. Default value "header:Authorization".
 	// Possible values:
 	// - "header:<name>"
+	// - "header:<name>:<scheme>", overrides AuthScheme for this header, e.g. "header:X-Internal:" for raw tokens
 	// - "query:<name>"
 	// - "param:<name>"
-	// - "cookie:<name>"
+	// - "cookie:<name>", encrypted cookies are supported by mounting the encryptcookie middleware before this one
+	// - "cookie-split:<prefix>", concatenates the cookies "<prefix>.0", "<prefix>.1", ... in order
+	// - "session:<key>", requires SessionStore
 	TokenLookup string
 
-	// AuthScheme to be used in the Authorization header.
-	// Optional. Default: "Bearer".
+	// TokenTransform is applied to the value found by TokenLookup before it is parsed, e.g. to strip a version
+	// prefix such as "v2." from the token. The returned string is used as the token from then on, its error is
+	// passed to ErrorHandler.
+	// Optional. Default: nil
+	TokenTransform func(raw string) (string, error)
+
+	// CookieOptions holds the attributes of cookies written by this package. All cookie writing
+	// features share these options, so that no cookie drops its security attributes.
+	// Optional. Default: CookieOptions{}
+	CookieOptions CookieOptions
+
+	// SessionStore is the session store used by "session:<key>" token lookups.
+	// Optional. Default: nil
+	SessionStore *session.Store
+
+	// VerificationCacheTTL enables a cache of successfully verified tokens, so that repeated requests with the
+	// same token skip the signature verification, which matters most for asymmetric keys. Tokens are cached for
+	// this duration, but never beyond their "exp" claim. Cached tokens are shared between requests and must
+	// not be modified by handlers. Tokens revoked within the TTL are still accepted.
+	// Optional. Default: 0, disabled
+	VerificationCacheTTL time.Duration
+
+	// VerificationCacheSize is the maximum number of tokens in the verification cache.
+	// Optional. Default: 1024
+	VerificationCacheSize int
+
+	// MaxTokenLength is the maximum length in bytes of an extracted token. Longer tokens are rejected
+	// with ErrJWTTooLarge before any parsing. A negative value disables the check.
+	// Optional. Default: 8192
+	MaxTokenLength int
+
+	// AuthScheme to be used in the Authorization header and any other header source of TokenLookup.
+	// Custom headers require the scheme as well, e.g. "X-Token: Bearer <token>". Setups sending bare tokens in a
+	// custom header, which were accepted before the scheme applied to all headers, must disable it for that
+	// header with an empty scheme in TokenLookup, e.g. "header:X-Token:".
+	// Optional. Default: "Bearer", or "DPoP" if DPoP is set.
 	AuthScheme string
 
 	// KeyFunc is a function that supplies the public key for JWT cryptographic verification.
 	// The function shall take care of verifying the signing algorithm and selecting the proper key.
 	// Internally, github.com/MicahParks/keyfunc/v2 package is used project defaults. If you need more customization,
 	// you can provide a jwt.Keyfunc using that package or make your own implementation.
+	// It only replaces the key resolution: the claim validations of the other fields still apply.
 	//
-	// At least one of the following is required: KeyFunc, JWKSetURLs, SigningKeys, or SigningKey.
-	// The order of precedence is: KeyFunc, JWKSetURLs, SigningKeys, SigningKey.
+	// At least one of the following is required: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, or SigningKey.
+	// The order of precedence is: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, SigningKey.
 	KeyFunc jwt.Keyfunc
 
 	// JWKSetURLs is a slice of HTTP URLs that contain the JSON Web Key Set (JWKS) used to verify the signatures of
@@ -83,9 +415,243 @@ type Config struct {
 	//   * Rate limit refreshes to once every 5 minutes.
 	//   * Timeout refreshes after 10 seconds.
 	//
-	// At least one of the following is required: KeyFunc, JWKSetURLs, SigningKeys, or SigningKey.
-	// The order of precedence is: KeyFunc, JWKSetURLs, SigningKeys, SigningKey.
+	// At least one of the following is required: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, or SigningKey.
+	// The order of precedence is: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, SigningKey.
 	JWKSetURLs []string
+
+	// SigningKeyResolver selects the signing key from the unverified claims of the token, e.g. a per-tenant
+	// HMAC secret by "iss". The token is then verified with the returned key, honoring its JWTAlg.
+	// The claims are untrusted at this point: use them for key selection only, never for authorization.
+	// It is ignored if KeyFunc is given, and takes precedence over all other key sources otherwise.
+	SigningKeyResolver func(unverifiedClaims jwt.MapClaims) (SigningKey, error)
+
+	// KeyProvider supplies the key for a "kid" from any key distribution backend, e.g. a key-value store such as
+	// etcd or Consul. It is consulted for every "kid" not found in JWKSetJSON or SigningKeys with the context of
+	// the request, and the keys it returns are cached by "kid", see KeyProviderCacheTTL and Middleware.InvalidateKey.
+	// The presence of the "kid" field in the JWT header is mandatory for this feature. It is ignored if KeyFunc or
+	// JWKSetURLs is given.
+	KeyProvider KeyProvider
+
+	// KeyProviderCacheTTL is how long a key returned by KeyProvider is cached, i.e. how long a key rotated under
+	// the same "kid" may still be used.
+	// Optional. Default: 5 * time.Minute
+	KeyProviderCacheTTL time.Duration
+
+	// KeyProviderNegativeCacheTTL is how long a failure of KeyProvider is cached, e.g. for a "kid" it does not
+	// know, so that tokens repeating the "kid" do not reach the key backend again. Failures count towards
+	// KeyProviderCacheSize.
+	// Optional. Default: 10 * time.Second
+	KeyProviderNegativeCacheTTL time.Duration
+
+	// KeyProviderCacheSize is the maximum number of keys returned by KeyProvider which are cached. When it is
+	// reached, the key expiring first is dropped.
+	// Optional. Default: 100
+	KeyProviderCacheSize int
+
+	// JWKSetJSON is a static JSON Web Key Set (JWKS) used to verify the signatures of JWTs, e.g. one embedded into
+	// the binary for air-gapped deployments. No network calls are made for it and it is never refreshed. The presence
+	// of the "kid" field in the JWT header and JWKs is mandatory for this feature. If JWKSetURLs is given as well,
+	// these keys are used in addition to the remote JWK Sets. Keys in SigningKeys take precedence on equal "kid".
+	//
+	// At least one of the following is required: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, or SigningKey.
+	// The order of precedence is: KeyFunc, JWKSetURLs, JWKSetJSON, SigningKeys, SigningKey.
+	JWKSetJSON json.RawMessage
+
+	// JWKSetAllowMissingKID allows tokens without a "kid" header to be verified against the JWK Sets given in
+	// JWKSetURLs. This only applies when the JWK Sets contain exactly one key in total; that key is then used
+	// regardless of the missing "kid". If more than one key is present, tokens without a "kid" are still rejected.
+	//
+	// Optional. Default: false
+	JWKSetAllowMissingKID bool
+
+	// ValidateOnStartup requires every JWK Set in JWKSetURLs to contain at least one usable key when the
+	// middleware is created, and panics with ErrJWKSetNoKeys otherwise. Unreachable URLs always panic at startup.
+	// Optional. Default: false
+	ValidateOnStartup bool
+
+	// JWKSetKeySelector chooses the key to verify a token with when more than one of the JWK Sets given in
+	// JWKSetURLs contains a key matching the token's "kid". It receives the candidate keys in the order of
+	// JWKSetURLs. KeySelectorFirst and KeySelectorLast are provided, custom functions may be used as well.
+	//
+	// Optional. Default: the first matching key found, in no particular order.
+	JWKSetKeySelector KeySelector
+
+	// AllowedJKUHosts is a list of hosts that may serve JWK Sets referenced by the "jku" (JWK Set URL) header of a
+	// token. Entries match either the host with port or the host name alone. When a token carries a "jku" header
+	// pointing to an allowed host, that JWK Set is fetched, cached and used to verify the token. Tokens with a "jku"
+	// header pointing anywhere else are rejected with ErrJWTJKUNotAllowed. Tokens without a "jku" header are verified
+	// with the other configured keys.
+	//
+	// Only "https" URLs are accepted. At most 16 of these JWK Sets are kept, dropping the least recently used
+	// one, and a URL failing to be fetched is not fetched again for JWKSetRefreshRateLimit.
+	//
+	// Every allowed host is fully trusted to issue tokens, so only list hosts under your control.
+	//
+	// Optional. Default: nil, the "jku" header is ignored.
+	AllowedJKUHosts []string
+
+	// JWKSetRefreshInterval is the interval in which the JWK Sets of JWKSetURLs and "jku" headers are refreshed
+	// in the background.
+	// Optional. Default: time.Hour
+	JWKSetRefreshInterval time.Duration
+
+	// JWKSetRefreshJitter randomizes the refresh interval of every JWK Set, so that many instances started at the
+	// same time do not refresh at the same moments and spike the load of the JWK Set provider. Each JWK Set is
+	// refreshed every JWKSetRefreshInterval plus a random duration below JWKSetRefreshJitter, chosen when it is
+	// first fetched.
+	// Optional. Default: 0, every JWK Set is refreshed exactly every JWKSetRefreshInterval.
+	JWKSetRefreshJitter time.Duration
+
+	// JWKSetNoBackgroundRefresh disables the periodic refreshes of JWK Sets, e.g. for short-lived serverless
+	// processes, leaving only the refreshes triggered by unknown "kid"s. keyfunc still starts an idle goroutine
+	// serving those unless JWKSetRefreshUnknownKID is false; Middleware.Close stops it.
+	// Optional. Default: false
+	JWKSetNoBackgroundRefresh bool
+
+	// JWKSetRefreshRateLimit is the minimum time between two refreshes of a JWK Set triggered by unknown "kid"s.
+	// Optional. Default: 5 * time.Minute
+	JWKSetRefreshRateLimit time.Duration
+
+	// JWKSetRefreshTimeout is the timeout of a single refresh of a JWK Set.
+	// Optional. Default: 10 * time.Second
+	JWKSetRefreshTimeout time.Duration
+
+	// JWKSetHTTPClient is the HTTP client fetching the JWK Sets of JWKSetURLs and "jku" headers, e.g. to trust
+	// a private certificate authority or to go through a proxy.
+	// Optional. Default: http.DefaultClient
+	JWKSetHTTPClient *http.Client
+
+	// JWKSetRefreshUnknownKID refreshes a JWK Set when a token with an unknown "kid" is seen,
+	// limited by JWKSetRefreshRateLimit.
+	// Optional. Default: true
+	JWKSetRefreshUnknownKID *bool
+
+	// JWKSetIsolated gives the middleware its own copy of the JWK Sets of JWKSetURLs. By default, configurations
+	// with the same JWKSetURLs, in any order, and the same JWKSetRefresh*, JWKSetAllowMissingKID,
+	// JWKSetStreaming and JWKSetHTTPClient settings share the fetched JWK Sets and their background refreshes,
+	// e.g. when the middleware is mounted on several route groups. The refreshes of shared JWK Sets report errors
+	// to the Logger of the first configuration and stop once every middleware sharing them was closed. JWK Sets
+	// are never shared if SigningKeys, JWKSetJSON, JWKSetKeySelector, OnJWKSRefresh, VerifyDiscoverySignature or
+	// FailOpenOnKeyUnavailable is set.
+	// Optional. Default: false
+	JWKSetIsolated bool
+
+	// JWKSetStreaming decodes the JWK Sets of JWKSetURLs and "jku" headers one key at a time while they are
+	// downloaded, instead of reading the whole response first, e.g. for providers with hundreds of keys on
+	// memory-constrained deployments. Members other than "keys" and keys with "use":"enc" are skipped without
+	// being kept in memory. It is ignored if VerifyDiscoverySignature is set, as signed JWK Sets are not JSON.
+	// Optional. Default: false
+	JWKSetStreaming bool
+
+	// VerifyDiscoverySignature requires the JWK Sets of JWKSetURLs and "jku" headers to be served as the payload
+	// of a JWS signed by DiscoveryTrustAnchor, e.g. "signed_jwks_uri" of OpenID Federation. This authenticates
+	// the JWK Set beyond TLS. JWK Sets failing the verification are rejected with ErrJWKSetSignature.
+	// Optional. Default: false
+	VerifyDiscoverySignature bool
+
+	// DiscoveryTrustAnchor is the key the signed JWK Sets are verified with. It is required with
+	// VerifyDiscoverySignature, and its JWTAlg is checked if set.
+	// Optional. Default: SigningKey{}
+	DiscoveryTrustAnchor SigningKey
+
+	// OnJWKSRefresh is called after every refresh of a JWK Set, including the initial one, with the URL of the
+	// JWK Set, the error of the refresh if it failed, and the time the refresh took. It may be used to export
+	// metrics and alert when a JWK Set has been failing to refresh for some time.
+	// Optional. Default: nil
+	OnJWKSRefresh func(url string, err error, duration time.Duration)
+
+	// FailOpenOnKeyUnavailable accepts tokens without verifying them when no key was found for their "kid"
+	// while a JWK Set of JWKSetURLs or "jku" headers failed to refresh, i.e. during an outage of the JWK Set
+	// provider. Other failures, such as signature mismatches, are still rejected. Accepted tokens are parsed
+	// without verification, validated and checked like verified ones, e.g. by DPoP, ReplayCache,
+	// AdditionalTokens and BeforeNext, stored into context marked with true under UnverifiedContextKey, and
+	// logged as a warning. Tokens whose key was fetched before the outage are still verified with that cached
+	// key, as the JWK Sets keep their last keys when a refresh fails.
+	//
+	// Anyone can forge tokens the middleware accepts during an outage, so only enable this for non-critical
+	// APIs. The JWK Sets are not shared with other configurations when this is set, see JWKSetIsolated.
+	// Optional. Default: false
+	FailOpenOnKeyUnavailable bool
+
+	// AdditionalTokens are further tokens verified after the main token, e.g. a device-binding token in a
+	// cookie next to an access token in the header. Each is stored into context under its own ContextKey.
+	// A failing required token fails the request, a failing optional one is skipped.
+	// Optional. Default: nil
+	AdditionalTokens []AdditionalToken
+
+	// Logger is used to report errors that occur outside of a request, e.g. failed background refreshes of JWK Sets.
+	// Optional. Default: log.Default()
+	Logger Logger
+
+	// DebugHMACFailures logs through Logger, prefixed with "DEBUG:", why HMAC signed tokens fail verification:
+	// on their structure or on their signature. Signature failures include the length of the configured
+	// secret and whether it may be hex or base64 encoded, e.g. to debug a secret configured in the wrong
+	// encoding, but never the secret itself. Logs grow with every invalid request, so only enable it while
+	// debugging.
+	// Optional. Default: false
+	DebugHMACFailures bool
+
+	// JSONUnmarshal decodes the JSON the middleware parses itself, e.g. JOSE headers, JWK Sets when looking up
+	// their key IDs and algorithms, and custom claims types read by ClaimsToLocals or SigningKeyResolver. It may
+	// be set to the decoder of the Fiber app, e.g. goccy/go-json or sonic. The claims of tokens and the keys of
+	// JWK Sets are decoded by the JWT and JWK libraries, which always use encoding/json.
+	// Optional. Default: json.Unmarshal
+	JSONUnmarshal utils.JSONUnmarshal
+
+	// StrictJSON rejects tokens whose header or payload contains an object with a duplicate key with
+	// ErrJWTDuplicateKey, as parsers may pick different values for such keys. The check decodes the header
+	// and payload of every token a second time, which roughly doubles the cost of decoding them.
+	// Optional. Default: false
+	StrictJSON bool
+
+	// AllowUnencodedPayload allows JWTs with an unencoded payload following RFC 7797, i.e. with the "b64" header
+	// set to false and listed in the "crit" header. Without it, such tokens fail signature verification.
+	// Optional. Default: false
+	AllowUnencodedPayload bool
+
+	// UnsafeAllowAlgNone allows unsigned tokens using the "none" algorithm to reach KeyFunc. By default, such
+	// tokens are rejected with ErrJWTAlgNone before any key lookup. Enabling this is almost never what you want:
+	// KeyFunc must additionally return jwt.UnsafeAllowNoneSignatureType for such a token to be accepted.
+	//
+	// Optional. Default: false
+	UnsafeAllowAlgNone bool
+
+	// KIDValidator checks the "kid" header of every token before its key is looked up, e.g. that it is a UUID or
+	// carries the prefix of the environment, so that a token is not verified with a key meant for another
+	// environment. Tokens without a "kid" header are passed an empty string. A returned error rejects the token
+	// with ErrJWTKID.
+	// Optional. Default: nil
+	KIDValidator func(kid string) error
+
+	// jwks holds the JWK Sets fetched from JWKSetURLs, if any.
+	jwks *keyfunc.MultipleJWKS
+
+	// hmacHints describes the HMAC keys for DebugHMACFailures, keyed by key ID.
+	hmacHints map[string]string
+
+	// releaseJWKS releases jwks, stopping their background refreshes unless other configurations share them.
+	releaseJWKS func()
+
+	// additional holds the complemented configurations of AdditionalTokens.
+	additional []Config
+
+	// provider caches the keys of KeyProvider, if used.
+	provider *providerKeyfunc
+
+	// keyFuncContext returns KeyFunc for the context of a request, if the key lookup needs it.
+	keyFuncContext func(ctx context.Context) jwt.Keyfunc
+
+	// jku holds the JWK Sets fetched from "jku" headers, if AllowedJKUHosts is set.
+	jku *jkuKeyfunc
+
+	// cache holds the verified tokens if VerificationCacheTTL is set.
+	cache *verificationCache
+
+	// keyHealth tracks failing refreshes of JWK Sets if FailOpenOnKeyUnavailable is set.
+	keyHealth *keyHealth
+
+	// dpop verifies the DPoP proofs if DPoP is enabled.
+	dpop *dpopVerifier
 }
 
 // SigningKey holds information about the recognized cryptographic keys used to sign JWTs by this program.
@@ -98,6 +664,151 @@ type SigningKey struct {
 	// Key is the cryptographic key used to sign JWTs. For supported types, please see
 	// https://github.com/golang-jwt/jwt.
 	Key interface{}
+	// KeyDerivation derives the HMAC key from Key, which is then the master secret as []byte. The parameters
+	// are validated when the middleware is created.
+	// Optional. Default: nil
+	KeyDerivation *KeyDerivation
+	// JWK is the key as a single JSON Web Key, e.g. {"kty":"EC","crv":"P-256","x":"...","y":"..."}, parsed into
+	// Key when the middleware is created. It is exclusive with Key. JWTAlg defaults to its "alg" member.
+	// Optional. Default: nil
+	JWK json.RawMessage
+}
+
+// AdditionalToken is a further token verified in the same pass as the main token.
+type AdditionalToken struct {
+	// Config of the additional token. Its token lookup, keys, claims, context keys and validation options
+	// are used, while handlers, Filter, TrustFunc, FailureLimiter and AdditionalTokens are ignored.
+	// ContextKey is required and must differ from the one of the main token.
+	Config Config
+
+	// Optional allows the request to continue if the additional token is missing or invalid.
+	// Optional. Default: false
+	Optional bool
+}
+
+// CookieOptions holds the attributes of cookies written by this package.
+type CookieOptions struct {
+	// Domain of the cookie.
+	// Optional. Default: ""
+	Domain string
+	// Path of the cookie.
+	// Optional. Default: "/"
+	Path string
+	// Secure restricts the cookie to HTTPS.
+	// Optional. Default: false
+	Secure bool
+	// HTTPOnly hides the cookie from JavaScript.
+	// Optional. Default: false
+	HTTPOnly bool
+	// SameSite is the SameSite attribute of the cookie: "lax", "strict" or "none".
+	// Optional. Default: "lax"
+	SameSite string
+}
+
+// Cookie returns a cookie with the given name, value and expiration using these options.
+// It may be used to set the token cookie read by a "cookie:<name>" token lookup.
+func (o CookieOptions) Cookie(name, value string, expires time.Time) *fiber.Cookie {
+	path := o.Path
+	if path == "" {
+		path = "/"
+	}
+	sameSite := o.SameSite
+	if sameSite == "" {
+		sameSite = fiber.CookieSameSiteLaxMode
+	}
+	return &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   o.Domain,
+		Path:     path,
+		Expires:  expires,
+		Secure:   o.Secure,
+		HTTPOnly: o.HTTPOnly,
+		SameSite: sameSite,
+	}
+}
+
+// continueOnSuccess wraps the given SuccessHandler and continues the chain after it, unless it failed or
+// already continued the chain or wrote a response.
+func continueOnSuccess(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		before := handledState(c)
+		if err := handler(c); err != nil {
+			return err
+		}
+		if c.Response().IsBodyStream() || handledState(c) != before {
+			return nil
+		}
+		return c.Next()
+	}
+}
+
+// responseState is what continueOnSuccess compares to tell whether a SuccessHandler handled the request.
+// Headers are not compared, as a SuccessHandler may set them and still leave the request to the chain.
+type responseState struct {
+	// route changes when c.Next moved on to the handlers of another route.
+	route  *fiber.Route
+	status int
+	size   int
+}
+
+func handledState(c *fiber.Ctx) responseState {
+	resp := c.Response()
+	return responseState{route: c.Route(), status: resp.StatusCode(), size: len(resp.Body())}
+}
+
+// responseErrorHandler returns the default ErrorHandler, using the given responses for missing and invalid
+// tokens if they are set.
+func responseErrorHandler(missing, invalid fiber.Handler) fiber.ErrorHandler {
+	if missing == nil && invalid == nil {
+		return defaultErrorHandler
+	}
+	return func(c *fiber.Ctx, err error) error {
+		switch {
+		case errors.Is(err, ErrJWTTooManyFailures):
+		case errors.Is(err, ErrJWTMissingOrMalformed):
+			if missing != nil {
+				return missing(c)
+			}
+		case invalid != nil:
+			return invalid(c)
+		}
+		return defaultErrorHandler(c, err)
+	}
+}
+
+// defaultErrorHandler responds with the status code suggested by StatusCode, with a JSON body if the client
+// prefers JSON, and plain text otherwise.
+func defaultErrorHandler(c *fiber.Ctx, err error) error {
+	switch status := StatusCode(err); status {
+	case fiber.StatusUnauthorized:
+		if errors.Is(err, ErrJWTMissingOrMalformed) {
+			return sendError(c, status, "invalid_request", "Missing or malformed JWT")
+		}
+		if errors.Is(err, ErrJWTNotYetValid) {
+			return sendError(c, status, "invalid_token", "JWT not valid yet")
+		}
+		return sendError(c, status, "invalid_token", "Invalid or expired JWT")
+	case fiber.StatusForbidden:
+		return sendError(c, status, "access_denied", "JWT not authorized for this resource")
+	case fiber.StatusTooManyRequests:
+		return sendError(c, status, "too_many_requests", "Too many failed JWT verifications")
+	default:
+		return sendError(c, status, "invalid_request", utils.StatusMessage(status))
+	}
+}
+
+// sendError sends the error as {"error":code,"message":message} if the client prefers JSON,
+// and the message as plain text otherwise.
+func sendError(c *fiber.Ctx, status int, code, message string) error {
+	c.Status(status)
+	if c.Accepts(fiber.MIMETextPlain, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+		return c.JSON(fiber.Map{
+			"error":   code,
+			"message": message,
+		})
+	}
+	return c.SendString(message)
 }
 
 // makeCfg function will check correctness of supplied configuration
@@ -110,49 +821,169 @@ func makeCfg(config []Config) (cfg Config) {
 		cfg.SuccessHandler = func(c *fiber.Ctx) error {
 			return c.Next()
 		}
+	} else if cfg.ContinueOnSuccess == nil || *cfg.ContinueOnSuccess {
+		cfg.SuccessHandler = continueOnSuccess(cfg.SuccessHandler)
 	}
 	if cfg.ErrorHandler == nil {
-		cfg.ErrorHandler = func(c *fiber.Ctx, err error) error {
-			if err.Error() == "Missing or malformed JWT" {
-				return c.Status(fiber.StatusBadRequest).SendString("Missing or malformed JWT")
-			}
-			return c.Status(fiber.StatusUnauthorized).SendString("Invalid or expired JWT")
+		cfg.ErrorHandler = responseErrorHandler(cfg.MissingTokenResponse, cfg.InvalidTokenResponse)
+	}
+	if cfg.SigningKey.Key == nil && len(cfg.SigningKey.JWK) == 0 && len(cfg.SigningKeys) == 0 && len(cfg.JWKSetURLs) == 0 && len(cfg.JWKSetJSON) == 0 && cfg.KeyFunc == nil && cfg.KeyProvider == nil && cfg.SigningKeyResolver == nil && len(cfg.AllowedJKUHosts) == 0 {
+		panic("Fiber: JWT middleware configuration: At least one of the following is required: KeyFunc, SigningKeyResolver, JWKSetURLs, KeyProvider, JWKSetJSON, SigningKeys, SigningKey, or AllowedJKUHosts.")
+	}
+	cfg.SigningKey = cfg.SigningKey.mustResolve()
+	if len(cfg.SigningKeys) > 0 {
+		// Copy the keys, so that the map of the caller keeps the master secrets and JWKs.
+		signingKeys := make(map[string]SigningKey, len(cfg.SigningKeys))
+		for kid, key := range cfg.SigningKeys {
+			signingKeys[kid] = key.mustResolve()
+		}
+		cfg.SigningKeys = signingKeys
+	}
+	if cfg.TrustedGatewayHeader != "" {
+		if cfg.TrustedGatewaySecret == "" {
+			panic("Fiber: JWT middleware configuration: TrustedGatewayHeader requires a TrustedGatewaySecret.")
 		}
+		cfg.TrustFunc = trustedGatewayFunc(cfg.TrustedGatewayHeader, cfg.TrustedGatewaySecret, cfg.TrustFunc)
+	}
+	if cfg.VerifyDiscoverySignature && cfg.DiscoveryTrustAnchor.Key == nil {
+		panic("Fiber: JWT middleware configuration: VerifyDiscoverySignature requires a DiscoveryTrustAnchor.")
+	}
+	if cfg.FailureLimiterKey == nil {
+		cfg.FailureLimiterKey = func(c *fiber.Ctx) string {
+			return c.IP()
+		}
+	}
+	if cfg.JSONUnmarshal == nil {
+		cfg.JSONUnmarshal = json.Unmarshal
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
 	}
-	if cfg.SigningKey.Key == nil && len(cfg.SigningKeys) == 0 && len(cfg.JWKSetURLs) == 0 && cfg.KeyFunc == nil {
-		panic("Fiber: JWT middleware configuration: At least one of the following is required: KeyFunc, JWKSetURLs, SigningKeys, or SigningKey.")
+	if cfg.DebugHMACFailures {
+		cfg.hmacHints = hmacKeyHints(cfg.SigningKey, cfg.SigningKeys)
 	}
 	if cfg.ContextKey == "" {
-		cfg.ContextKey = "user"
+		cfg.ContextKey = defaultContextKey
+	}
+	if cfg.RawTokenContextKey == "" {
+		cfg.RawTokenContextKey = cfg.ContextKey + rawTokenContextKeySuffix
 	}
 	if cfg.Claims == nil {
 		cfg.Claims = jwt.MapClaims{}
 	}
+	if err := validateClaims(cfg.Claims); err != nil {
+		panic("Fiber: JWT middleware configuration: " + err.Error())
+	}
+	if cfg.ScopeClaim == "" {
+		cfg.ScopeClaim = defaultScopeClaim
+	}
+	if cfg.ScopeDelimiter == "" {
+		cfg.ScopeDelimiter = defaultScopeDelimiter
+	}
+	if cfg.MaxNestingDepth == 0 {
+		cfg.MaxNestingDepth = defaultMaxNestingDepth
+	}
+	if cfg.TimeFunc == nil {
+		cfg.TimeFunc = time.Now
+	}
+	if cfg.FailOpenOnKeyUnavailable {
+		cfg.keyHealth = newKeyHealth()
+	}
+	if cfg.JWKSetRefreshInterval == 0 {
+		cfg.JWKSetRefreshInterval = time.Hour
+	}
+	if cfg.JWKSetRefreshJitter < 0 {
+		panic("Fiber: JWT middleware configuration: JWKSetRefreshJitter must not be negative")
+	}
+	if cfg.JWKSetRefreshRateLimit == 0 {
+		cfg.JWKSetRefreshRateLimit = 5 * time.Minute
+	}
+	if cfg.JWKSetRefreshTimeout == 0 {
+		cfg.JWKSetRefreshTimeout = 10 * time.Second
+	}
+	if cfg.KeyProviderCacheTTL == 0 {
+		cfg.KeyProviderCacheTTL = defaultKeyProviderCacheTTL
+	}
+	if cfg.KeyProviderNegativeCacheTTL == 0 {
+		cfg.KeyProviderNegativeCacheTTL = defaultKeyProviderNegativeCacheTTL
+	}
+	if cfg.KeyProviderCacheSize <= 0 {
+		cfg.KeyProviderCacheSize = defaultKeyProviderCacheSize
+	}
+	if cfg.JWKSetRefreshUnknownKID == nil {
+		refreshUnknownKID := true
+		cfg.JWKSetRefreshUnknownKID = &refreshUnknownKID
+	}
+	if cfg.MaxTokenLength == 0 {
+		cfg.MaxTokenLength = defaultMaxTokenLength
+	}
+	if len(cfg.DecryptionAlgorithms) == 0 {
+		cfg.DecryptionAlgorithms = defaultDecryptionAlgorithms
+	}
+	if len(cfg.DecryptionEncryptions) == 0 {
+		cfg.DecryptionEncryptions = defaultDecryptionEncryptions
+	}
 	if cfg.TokenLookup == "" {
 		cfg.TokenLookup = defaultTokenLookup
-		// set AuthScheme as "Bearer" only if TokenLookup is set to default.
-		if cfg.AuthScheme == "" {
-			cfg.AuthScheme = "Bearer"
+	}
+	// The scheme applies to all header sources, not only to the default one.
+	if cfg.AuthScheme == "" {
+		cfg.AuthScheme = "Bearer"
+		if cfg.DPoP {
+			cfg.AuthScheme = dpopAuthScheme
 		}
 	}
+	if len(cfg.getExtractors()) == 0 {
+		panic("Fiber: JWT middleware configuration: TokenLookup " + strconv.Quote(cfg.TokenLookup) + " contains no supported source. Supported sources are: header, query, param, cookie, cookie-split, session.")
+	}
 
+	if cfg.KeyFunc == nil && cfg.SigningKeyResolver != nil {
+		cfg.KeyFunc = resolverKeyFunc(cfg.SigningKeyResolver, cfg.JSONUnmarshal)
+	}
+	// indexes hold the keys of the JWK Sets which keyfunc cannot tell apart or parse, see keySetIndex.
+	var indexes []*keySetIndex
+	// jwksIndex is the one of the JWK Sets of JWKSetURLs.
+	var jwksIndex *keySetIndex
 	if cfg.KeyFunc == nil {
-		if len(cfg.SigningKeys) > 0 || len(cfg.JWKSetURLs) > 0 {
+		if len(cfg.SigningKeys) > 0 || len(cfg.JWKSetURLs) > 0 || len(cfg.JWKSetJSON) > 0 || cfg.KeyProvider != nil {
 			var givenKeys map[string]keyfunc.GivenKey
+			if len(cfg.JWKSetJSON) > 0 {
+				var err error
+				givenKeys, err = keyfunc.NewGivenKeysFromJSON(cfg.JWKSetJSON)
+				if err != nil {
+					panic("Failed to create keyfunc from JWK Set JSON: " + err.Error())
+				}
+				index := newKeySetIndex(cfg.JSONUnmarshal)
+				index.update("", cfg.JWKSetJSON)
+				indexes = append(indexes, index)
+			}
 			if cfg.SigningKeys != nil {
-				givenKeys = make(map[string]keyfunc.GivenKey, len(cfg.SigningKeys))
+				if givenKeys == nil {
+					givenKeys = make(map[string]keyfunc.GivenKey, len(cfg.SigningKeys))
+				}
 				for kid, key := range cfg.SigningKeys {
-					givenKeys[kid] = keyfunc.NewGivenCustom(key, keyfunc.GivenKeyOptions{
+					givenKeys[kid] = keyfunc.NewGivenCustom(key.Key, keyfunc.GivenKeyOptions{
 						Algorithm: key.JWTAlg,
 					})
 				}
 			}
 			if len(cfg.JWKSetURLs) > 0 {
 				var err error
-				cfg.KeyFunc, err = multiKeyfunc(givenKeys, cfg.JWKSetURLs)
+				cfg.jwks, jwksIndex, cfg.releaseJWKS, err = cfg.acquireJWKS(givenKeys)
 				if err != nil {
 					panic("Failed to create keyfunc from JWK Set URL: " + err.Error())
 				}
+				indexes = append(indexes, jwksIndex)
+				if cfg.ValidateOnStartup {
+					if err = validateJWKSets(cfg.jwks); err != nil {
+						closeConfig(&cfg)
+						panic("Fiber: JWT middleware configuration: " + err.Error())
+					}
+				}
+				cfg.KeyFunc = cfg.jwks.Keyfunc
+			} else if cfg.KeyProvider != nil {
+				cfg.provider = newProviderKeyfunc(cfg.KeyProvider, givenKeys, cfg.KeyProviderCacheTTL, cfg.KeyProviderNegativeCacheTTL, cfg.KeyProviderCacheSize, cfg.TimeFunc)
+				cfg.KeyFunc = cfg.provider.keyfunc(context.Background())
 			} else {
 				cfg.KeyFunc = keyfunc.NewGiven(givenKeys).Keyfunc
 			}
@@ -160,57 +991,162 @@ func makeCfg(config []Config) (cfg Config) {
 			cfg.KeyFunc = signingKeyFunc(cfg.SigningKey)
 		}
 	}
+	if len(cfg.AllowedJKUHosts) > 0 {
+		cfg.jku = newJKUKeyfunc(cfg.AllowedJKUHosts, cfg.JWKSetRefreshRateLimit, cfg.keyfuncOptions, newKeySetIndex(cfg.JSONUnmarshal))
+	}
+	jku, validator, allowAlgNone := cfg.jku, cfg.KIDValidator, cfg.UnsafeAllowAlgNone
+	issuers, multiJWKS := cfg.issuerKeySources(), cfg.jwks
+	// wrap adds the key lookups and checks shared by all key sources to the given jwt.Keyfunc.
+	wrap := func(keyFunc jwt.Keyfunc) jwt.Keyfunc {
+		if len(indexes) > 0 {
+			keyFunc = kidAlgKeyfunc(indexes, keyFunc)
+		}
+		// Tokens with a "jku" header only use its JWK Set, never the indexes of the other key sources.
+		if jku != nil {
+			keyFunc = jku.keyfunc(keyFunc)
+		}
+		// Tokens of issuers with key sources of their own only use those.
+		if len(issuers) > 0 {
+			keyFunc = issuerKeyfunc(issuers, multiJWKS, jwksIndex, keyFunc)
+		}
+		keyFunc = keyCheckKeyFunc(keyFunc)
+		if validator != nil {
+			keyFunc = kidValidatorKeyFunc(validator, keyFunc)
+		}
+		if !allowAlgNone {
+			keyFunc = rejectAlgNoneKeyFunc(keyFunc)
+		}
+		return keyFunc
+	}
+	cfg.KeyFunc = wrap(cfg.KeyFunc)
+	if provider := cfg.provider; provider != nil {
+		cfg.keyFuncContext = func(ctx context.Context) jwt.Keyfunc {
+			return wrap(provider.keyfunc(ctx))
+		}
+	}
+	if cfg.DPoP {
+		cfg.dpop = newDPoPVerifier(cfg.Leeway, cfg.TimeFunc)
+	}
+	if cfg.VerificationCacheTTL > 0 {
+		if cfg.VerificationCacheSize <= 0 {
+			cfg.VerificationCacheSize = defaultVerificationCacheSize
+		}
+		cfg.cache = newVerificationCache(cfg.VerificationCacheTTL, cfg.VerificationCacheSize, cfg.TimeFunc)
+	}
+	if len(cfg.AdditionalTokens) > 0 {
+		cfg.additional = make([]Config, len(cfg.AdditionalTokens))
+		for i, additional := range cfg.AdditionalTokens {
+			if additional.Config.ContextKey == "" || additional.Config.ContextKey == cfg.ContextKey {
+				panic("Fiber: JWT middleware configuration: AdditionalTokens require a ContextKey different from the one of the main token.")
+			}
+			cfg.additional[i] = makeCfg([]Config{additional.Config})
+		}
+	}
 
 	return cfg
 }
 
-func multiKeyfunc(givenKeys map[string]keyfunc.GivenKey, jwkSetURLs []string) (jwt.Keyfunc, error) {
-	opts := keyfuncOptions(givenKeys)
-	multiple := make(map[string]keyfunc.Options, len(jwkSetURLs))
-	for _, url := range jwkSetURLs {
-		multiple[url] = opts
+func multiKeyfunc(givenKeys map[string]keyfunc.GivenKey, cfg Config, index *keySetIndex) (*keyfunc.MultipleJWKS, error) {
+	multiple := make(map[string]keyfunc.Options, len(cfg.JWKSetURLs))
+	for _, url := range cfg.JWKSetURLs {
+		multiple[url] = indexKeySet(url, cfg.keyfuncOptions(url, givenKeys), index)
 	}
 	multiOpts := keyfunc.MultipleOptions{
 		KeySelector: keyfunc.KeySelectorFirst,
 	}
+	if cfg.JWKSetKeySelector != nil {
+		multiOpts.KeySelector = orderedKeySelector(cfg.JWKSetURLs, cfg.JWKSetKeySelector)
+	}
+	if cfg.JWKSetAllowMissingKID {
+		multiOpts.KeySelector = singleKeySelector(multiOpts.KeySelector)
+	}
 	multi, err := keyfunc.GetMultiple(multiple, multiOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get multiple JWK Set URLs: %w", err)
 	}
-	return multi.Keyfunc, nil
+	return multi, nil
 }
 
-func keyfuncOptions(givenKeys map[string]keyfunc.GivenKey) keyfunc.Options {
-	return keyfunc.Options{
+func (cfg *Config) keyfuncOptions(jwksURL string, givenKeys map[string]keyfunc.GivenKey) keyfunc.Options {
+	opts := keyfunc.Options{
 		GivenKeys: givenKeys,
+		Client:    cfg.JWKSetHTTPClient,
 		RefreshErrorHandler: func(err error) {
-			log.Printf("Failed to perform background refresh of JWK Set: %s.", err)
+			cfg.Logger.Printf("Failed to perform background refresh of JWK Set: %s.", err)
 		},
-		RefreshInterval:   time.Hour,
-		RefreshRateLimit:  time.Minute * 5,
-		RefreshTimeout:    time.Second * 10,
-		RefreshUnknownKID: true,
+		RefreshInterval:   cfg.JWKSetRefreshInterval + randomDuration(cfg.JWKSetRefreshJitter),
+		RefreshRateLimit:  cfg.JWKSetRefreshRateLimit,
+		RefreshTimeout:    cfg.JWKSetRefreshTimeout,
+		RefreshUnknownKID: *cfg.JWKSetRefreshUnknownKID,
+		RequestFactory:    jwksRequestFactory,
+		ResponseExtractor: jwksResponseExtractor,
+	}
+	if cfg.JWKSetNoBackgroundRefresh {
+		opts.RefreshInterval = 0
+	}
+	if cfg.VerifyDiscoverySignature {
+		opts.ResponseExtractor = signedJWKSResponseExtractor(cfg.DiscoveryTrustAnchor, opts.ResponseExtractor)
+	} else if cfg.JWKSetStreaming {
+		opts.ResponseExtractor = streamingJWKSResponseExtractor
+	}
+	if cfg.OnJWKSRefresh != nil {
+		opts = observeRefresh(jwksURL, opts, cfg.OnJWKSRefresh, cfg.JSONUnmarshal)
+	}
+	if cfg.keyHealth != nil {
+		opts = observeRefresh(jwksURL, opts, cfg.keyHealth.record, cfg.JSONUnmarshal)
+	}
+	return opts
+}
+
+// randomDuration returns a random duration in [0, max), or 0 if max is not positive.
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	// crypto/rand, as the global source of math/rand is not seeded randomly for this module's Go version.
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
 	}
+	return time.Duration(n.Int64())
 }
 
 // getExtractors function will create a slice of functions which will be used
-// for token sarch  and will perform extraction of the value
+// for token search and will perform extraction of the value.
+// Source names are case-insensitive and surrounding whitespace is ignored.
 func (cfg *Config) getExtractors() []jwtExtractor {
 	// Initialize
 	extractors := make([]jwtExtractor, 0)
 	rootParts := strings.Split(cfg.TokenLookup, ",")
 	for _, rootPart := range rootParts {
-		parts := strings.Split(strings.TrimSpace(rootPart), ":")
+		parts := strings.SplitN(rootPart, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		source := strings.ToLower(strings.TrimSpace(parts[0]))
+		name := strings.TrimSpace(parts[1])
 
-		switch parts[0] {
+		switch source {
 		case "header":
-			extractors = append(extractors, jwtFromHeader(parts[1], cfg.AuthScheme))
+			// An optional third segment overrides AuthScheme for this header, an empty one disables it.
+			scheme := cfg.AuthScheme
+			if i := strings.Index(name, ":"); i >= 0 {
+				name, scheme = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+			}
+			extractors = append(extractors, jwtFromHeader(name, scheme))
 		case "query":
-			extractors = append(extractors, jwtFromQuery(parts[1]))
+			extractors = append(extractors, jwtFromQuery(name))
 		case "param":
-			extractors = append(extractors, jwtFromParam(parts[1]))
+			extractors = append(extractors, jwtFromParam(name))
 		case "cookie":
-			extractors = append(extractors, jwtFromCookie(parts[1]))
+			extractors = append(extractors, jwtFromCookie(name))
+		case "cookie-split":
+			extractors = append(extractors, jwtFromSplitCookies(name))
+		case "session":
+			if cfg.SessionStore == nil {
+				panic("Fiber: JWT middleware configuration: SessionStore is required for \"session:<key>\" token lookups.")
+			}
+			extractors = append(extractors, jwtFromSession(cfg.SessionStore, name))
 		}
 	}
 	return extractors
@@ -230,3 +1166,250 @@ func signingKeyFunc(key SigningKey) jwt.Keyfunc {
 		return key.Key, nil
 	}
 }
+
+// trustedGatewayFunc returns a TrustFunc trusting requests whose header carries the secret of the gateway,
+// falling back to next, if any.
+func trustedGatewayFunc(header, secret string, next func(*fiber.Ctx) bool) func(*fiber.Ctx) bool {
+	return func(c *fiber.Ctx) bool {
+		if subtle.ConstantTimeCompare([]byte(c.Get(header)), []byte(secret)) == 1 {
+			return true
+		}
+		return next != nil && next(c)
+	}
+}
+
+// resolverKeyFunc returns a jwt.Keyfunc using the key the resolver selects from the unverified claims.
+func resolverKeyFunc(resolver func(unverifiedClaims jwt.MapClaims) (SigningKey, error), unmarshal utils.JSONUnmarshal) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		key, err := resolver(claimsMap(token.Claims, unmarshal))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve signing key: %w", err)
+		}
+		if key, err = key.resolve(); err != nil {
+			return nil, err
+		}
+		return signingKeyFunc(key)(token)
+	}
+}
+
+// rejectAlgNoneKeyFunc wraps the given jwt.Keyfunc and rejects tokens using the "none" algorithm.
+func rejectAlgNoneKeyFunc(keyFunc jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if alg, ok := token.Header["alg"].(string); ok && strings.EqualFold(alg, jwt.SigningMethodNone.Alg()) {
+			return nil, ErrJWTAlgNone
+		}
+		return keyFunc(token)
+	}
+}
+
+// kidValidatorKeyFunc wraps the given jwt.Keyfunc and rejects tokens whose "kid" header fails the validator.
+func kidValidatorKeyFunc(validator func(kid string) error, keyFunc jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if err := validator(kid); err != nil {
+			return nil, invalidKIDError{kid: kid, err: err}
+		}
+		return keyFunc(token)
+	}
+}
+
+// validateClaims checks that a new instance of the claims can be created for every request, so that
+// no state is shared between concurrent requests.
+func validateClaims(claims jwt.Claims) error {
+	if m, ok := claims.(jwt.MapClaims); ok {
+		if len(m) > 0 {
+			return errors.New("Claims of type jwt.MapClaims must be empty, their values would be ignored")
+		}
+		return nil
+	}
+	t := reflect.TypeOf(claims)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Claims must be jwt.MapClaims or a pointer to a struct, e.g. &MyClaims{}, got %s", t)
+	}
+	return nil
+}
+
+// validateToken performs the optional checks of a parsed and verified token.
+func (cfg *Config) validateToken(token *jwt.Token) error {
+	if cfg.ExpectedTokenType != "" {
+		if err := validateTokenType(token, cfg.ExpectedTokenType); err != nil {
+			return err
+		}
+	}
+	if cfg.RejectFutureIssued {
+		if err := cfg.validateIssuedAt(token.Claims); err != nil {
+			return err
+		}
+	}
+	if cfg.RequireExpiration {
+		if err := validateExpirationPresent(token.Claims); err != nil {
+			return err
+		}
+	}
+	if cfg.RequireSubject {
+		if sub, err := token.Claims.GetSubject(); err != nil || sub == "" {
+			return ErrJWTMissingSubject
+		}
+	}
+	if len(cfg.ExpectedIssuers) > 0 {
+		if err := validateIssuer(token.Claims, cfg.ExpectedIssuers); err != nil {
+			return err
+		}
+	}
+	if len(cfg.IssuerPolicies) > 0 {
+		if err := cfg.validateIssuerPolicy(token); err != nil {
+			return err
+		}
+	}
+	if cfg.ExpectedAuthorizedParty != "" || cfg.RequireAuthorizedPartyForMultipleAudiences {
+		if err := cfg.validateAuthorizedParty(token.Claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateIssuer checks that the "iss" claim is one of the expected issuers.
+func validateIssuer(claims jwt.Claims, expected []string) error {
+	iss, err := claims.GetIssuer()
+	if err != nil {
+		return err
+	}
+	for _, issuer := range expected {
+		if subtle.ConstantTimeCompare([]byte(iss), []byte(issuer)) == 1 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unexpected issuer %q", jwt.ErrTokenInvalidIssuer, iss)
+}
+
+// validateAudienceType checks that the "aud" claim of jwt.MapClaims is a string or an array of strings. The
+// audience validation of github.com/golang-jwt/jwt treats other values like a missing claim, which would be
+// reported as a token not meant for this resource instead of a malformed one.
+func validateAudienceType(claims jwt.Claims) error {
+	m, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	aud, ok := m["aud"]
+	if !ok {
+		return nil
+	}
+	if _, ok = aud.([]string); ok {
+		return nil
+	}
+	var audiences []string
+	if !convertStrings(aud, &audiences) {
+		return fmt.Errorf("%w: the \"aud\" claim must be a string or an array of strings", jwt.ErrTokenInvalidClaims)
+	}
+	return nil
+}
+
+// onlyExpired reports whether expiry is the only claim validation failure within err.
+func onlyExpired(err error) bool {
+	expired := false
+	var walk func(err error) bool
+	walk = func(err error) bool {
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				if !walk(inner) {
+					return false
+				}
+			}
+			return true
+		case interface{ Unwrap() error }:
+			return walk(e.Unwrap())
+		}
+		switch err {
+		case jwt.ErrTokenExpired:
+			expired = true
+			return true
+		case jwt.ErrTokenInvalidClaims:
+			return true
+		}
+		return false
+	}
+	return walk(err) && expired
+}
+
+// invalidKIDError marks a failure of KIDValidator as ErrJWTKID while keeping the error of the validator.
+type invalidKIDError struct {
+	kid string
+	err error
+}
+
+func (e invalidKIDError) Error() string {
+	return fmt.Sprintf("%s: %q: %s", ErrJWTKID, e.kid, e.err)
+}
+
+func (e invalidKIDError) Is(target error) bool {
+	return target == ErrJWTKID
+}
+
+func (e invalidKIDError) StatusCode() int {
+	return fiber.StatusUnauthorized
+}
+
+func (e invalidKIDError) Unwrap() error {
+	return e.err
+}
+
+// notYetValidError marks a jwt.ErrTokenNotValidYet failure as ErrJWTNotYetValid while keeping the original error chain.
+type notYetValidError struct {
+	err error
+}
+
+func (e notYetValidError) Error() string {
+	return ErrJWTNotYetValid.Error() + ": " + e.err.Error()
+}
+
+func (e notYetValidError) Is(target error) bool {
+	return target == ErrJWTNotYetValid
+}
+
+func (e notYetValidError) StatusCode() int {
+	return fiber.StatusUnauthorized
+}
+
+func (e notYetValidError) Unwrap() error {
+	return e.err
+}
+
+// validateTokenType checks the "typ" header against the expected type. Following RFC 7515 section 4.1.9,
+// the comparison is case-insensitive and the "application/" prefix may be omitted.
+func validateTokenType(token *jwt.Token, expected string) error {
+	typ, _ := token.Header["typ"].(string)
+	normalize := func(t string) string {
+		t = strings.ToLower(t)
+		return strings.TrimPrefix(t, "application/")
+	}
+	if normalize(typ) != normalize(expected) {
+		return fmt.Errorf("%w: expected %q, got %q", ErrJWTType, expected, typ)
+	}
+	return nil
+}
+
+// validateExpirationPresent checks that the claims contain an "exp" claim. Its value is validated by the parser.
+func validateExpirationPresent(claims jwt.Claims) error {
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return err
+	}
+	if exp == nil {
+		return fmt.Errorf("%w: exp claim is required", jwt.ErrTokenRequiredClaimMissing)
+	}
+	return nil
+}
+
+// validateIssuedAt checks that the "iat" claim, if present, is not in the future.
+func (cfg *Config) validateIssuedAt(claims jwt.Claims) error {
+	iat, err := claims.GetIssuedAt()
+	if err != nil {
+		return err
+	}
+	if iat != nil && iat.After(cfg.TimeFunc().Add(cfg.Leeway)) {
+		return ErrJWTIssuedInFuture
+	}
+	return nil
+}
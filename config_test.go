@@ -1,7 +1,13 @@
 package jwtware
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 func TestPanicOnMissingConfiguration(t *testing.T) {
@@ -46,6 +52,12 @@ func TestDefaultConfiguration(t *testing.T) {
 	if cfg.Claims == nil {
 		t.Fatalf("Default claims should not be 'nil'")
 	}
+	if cfg.Logger == nil {
+		t.Fatalf("Default logger should not be 'nil'")
+	}
+	if cfg.MaxTokenLength != defaultMaxTokenLength {
+		t.Fatalf("Default max token length should be '%v'", defaultMaxTokenLength)
+	}
 
 	if cfg.TokenLookup != defaultTokenLookup {
 		t.Fatalf("Default token lookup should be '%v'", defaultTokenLookup)
@@ -53,6 +65,37 @@ func TestDefaultConfiguration(t *testing.T) {
 	if cfg.AuthScheme != "Bearer" {
 		t.Fatalf("Default auth scheme should be 'Bearer'")
 	}
+	if cfg.JWKSetRefreshInterval != time.Hour || cfg.JWKSetRefreshRateLimit != 5*time.Minute || cfg.JWKSetRefreshTimeout != 10*time.Second {
+		t.Fatalf("Default JWK Set refresh interval, rate limit and timeout should be 1h, 5m and 10s")
+	}
+	if cfg.JWKSetRefreshUnknownKID == nil || !*cfg.JWKSetRefreshUnknownKID {
+		t.Fatalf("JWK Sets should be refreshed on unknown kids by default")
+	}
+}
+
+func TestJWKSetRefreshOptions(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	refreshUnknownKID := false
+	cfg := makeCfg([]Config{{
+		SigningKey:              SigningKey{Key: []byte("")},
+		JWKSetRefreshInterval:   time.Minute,
+		JWKSetRefreshRateLimit:  time.Second,
+		JWKSetRefreshTimeout:    time.Second * 2,
+		JWKSetRefreshUnknownKID: &refreshUnknownKID,
+	}})
+
+	// Act
+	opts := cfg.keyfuncOptions("https://example.com/jwks.json", nil)
+
+	// Assert
+	if opts.RefreshInterval != time.Minute || opts.RefreshRateLimit != time.Second || opts.RefreshTimeout != time.Second*2 {
+		t.Fatalf("JWK Set refresh options should be taken from the configuration")
+	}
+	if opts.RefreshUnknownKID {
+		t.Fatalf("JWK Sets should not be refreshed on unknown kids when disabled")
+	}
 }
 
 func TestExtractorsInitialization(t *testing.T) {
@@ -109,3 +152,280 @@ func TestCustomTokenLookup(t *testing.T) {
 		t.Fatalf("AuthScheme should be %s", scheme)
 	}
 }
+
+func TestPanicOnUnsafeClaims(t *testing.T) {
+	t.Parallel()
+
+	cases := []jwt.Claims{
+		jwt.MapClaims{"sub": "1234567890"},
+		jwt.RegisteredClaims{},
+	}
+
+	for _, claims := range cases {
+		func() {
+			defer func() {
+				// Assert
+				if err := recover(); err == nil {
+					t.Fatalf("Middleware should panic on claims of type %T", claims)
+				}
+			}()
+
+			// Arrange
+			config := append(make([]Config, 0), Config{
+				SigningKey: SigningKey{Key: []byte("")},
+				Claims:     claims,
+			})
+
+			// Act
+			makeCfg(config)
+		}()
+	}
+}
+
+func TestSafeClaims(t *testing.T) {
+	t.Parallel()
+
+	cases := []jwt.Claims{
+		jwt.MapClaims{},
+		&jwt.RegisteredClaims{},
+	}
+
+	for _, claims := range cases {
+		if err := validateClaims(claims); err != nil {
+			t.Fatalf("Claims of type %T should be valid: %s", claims, err)
+		}
+	}
+}
+
+func TestExtractorsLenientParsing(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	cfg := Config{
+		SigningKey:  SigningKey{Key: []byte("")},
+		TokenLookup: "Header:Authorization, QUERY : token ,  cookie : token ,malformed",
+	}
+
+	// Act
+	extractors := cfg.getExtractors()
+
+	// Assert
+	if len(extractors) != 3 {
+		t.Fatalf("Extractors should be created for whitespaced and mixed-case lookups, got %d", len(extractors))
+	}
+}
+
+func TestPanicOnUnsupportedTokenLookup(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		// Assert
+		if err := recover(); err == nil {
+			t.Fatalf("Middleware should panic on a token lookup without supported sources")
+		}
+	}()
+
+	// Arrange
+	config := append(make([]Config, 0), Config{
+		SigningKey:  SigningKey{Key: []byte("")},
+		TokenLookup: "something:something,form:token",
+	})
+
+	// Act
+	makeCfg(config)
+}
+
+func TestCookieOptions(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	expires := time.Now().Add(time.Hour)
+	options := CookieOptions{
+		Domain:   "example.com",
+		Secure:   true,
+		HTTPOnly: true,
+	}
+
+	// Act
+	cookie := options.Cookie("token", "value", expires)
+
+	// Assert
+	if cookie.Name != "token" || cookie.Value != "value" || !cookie.Expires.Equal(expires) {
+		t.Fatalf("Cookie should have the given name, value and expiration")
+	}
+	if cookie.Domain != "example.com" || !cookie.Secure || !cookie.HTTPOnly {
+		t.Fatalf("Cookie should have the configured attributes")
+	}
+	if cookie.Path != "/" || cookie.SameSite != fiber.CookieSameSiteLaxMode {
+		t.Fatalf("Cookie should default to path '/' and SameSite 'lax'")
+	}
+}
+
+func TestJWKSetRefreshJitter(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	cfg := makeCfg([]Config{{
+		SigningKey:            SigningKey{Key: []byte("")},
+		JWKSetRefreshInterval: time.Minute,
+		JWKSetRefreshJitter:   time.Second,
+	}})
+
+	// Act
+	intervals := make(map[time.Duration]struct{})
+	for i := 0; i < 10; i++ {
+		opts := cfg.keyfuncOptions("https://example.com/jwks.json", nil)
+		intervals[opts.RefreshInterval] = struct{}{}
+	}
+
+	// Assert
+	for interval := range intervals {
+		if interval < time.Minute || interval >= time.Minute+time.Second {
+			t.Fatalf("refresh interval %s should be within the jitter window", interval)
+		}
+	}
+	if len(intervals) < 2 {
+		t.Fatalf("refresh intervals should be randomized")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("a negative jitter should panic")
+		}
+	}()
+	makeCfg([]Config{{SigningKey: SigningKey{Key: []byte("")}, JWKSetRefreshJitter: -time.Second}})
+}
+
+func TestJWKSetNoBackgroundRefresh(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	cfg := makeCfg([]Config{{
+		SigningKey:                SigningKey{Key: []byte("")},
+		JWKSetNoBackgroundRefresh: true,
+	}})
+
+	// Act
+	opts := cfg.keyfuncOptions("https://example.com/jwks.json", nil)
+
+	// Assert
+	if opts.RefreshInterval != 0 {
+		t.Fatalf("JWK Sets should not be refreshed in the background when disabled")
+	}
+	if !opts.RefreshUnknownKID {
+		t.Fatalf("JWK Sets should still be refreshed on unknown kids")
+	}
+}
+
+func TestAuthSchemeDefaultForCustomHeader(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := []Config{{
+		SigningKey:  SigningKey{Key: []byte("")},
+		TokenLookup: "header:X-Auth",
+	}}
+
+	// Act
+	cfg := makeCfg(config)
+
+	// Assert
+	if cfg.AuthScheme != "Bearer" {
+		t.Fatalf("AuthScheme should default to 'Bearer' for custom header lookups")
+	}
+}
+
+func TestStreamKeySet(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		keySet string
+		want   string
+		err    bool
+	}{
+		{keySet: `{"keys":[]}`, want: `{"keys":[]}`},
+		{keySet: `{}`, want: `{"keys":[]}`},
+		{
+			keySet: `{"other": [1, {"a": "b"}], "keys": [ {"kid": "1", "k": "x"}, {"kid": "2", "use": "enc"},
+				{"kid": "3", "use": "sig"} ], "n": null}`,
+			want: `{"keys":[{"kid":"1","k":"x"},{"kid":"3","use":"sig"}]}`,
+		},
+		{keySet: `{"keys":[{"kid":"1"}],"keys":[{"kid":"2"}]}`, want: `{"keys":[{"kid":"2"}]}`},
+		{keySet: `[]`, err: true},
+		{keySet: `{"keys":{}}`, err: true},
+		{keySet: `{"keys":[{"kid":`, err: true},
+	}
+
+	for _, tc := range cases {
+		// Act
+		keySet, err := streamKeySet(strings.NewReader(tc.keySet))
+
+		// Assert
+		if tc.err {
+			if err == nil {
+				t.Fatalf("expected an error for %s", tc.keySet)
+			}
+			continue
+		}
+		if err != nil || string(keySet) != tc.want {
+			t.Fatalf("got %s, %v for %s, want %s", keySet, err, tc.keySet, tc.want)
+		}
+	}
+}
+
+func TestJTISet(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	now := time.Now()
+	jtis := newJTISet(2)
+
+	// Act & Assert
+	if !jtis.add("a", now.Add(time.Minute), now) || jtis.add("a", now.Add(time.Minute), now) {
+		t.Fatalf("a jti should only be added once")
+	}
+	if !jtis.add("b", now.Add(2*time.Minute), now) || !jtis.contains("b", now) {
+		t.Fatalf("b should be added")
+	}
+	// The set is full, so "a" expiring first is dropped.
+	if !jtis.add("c", now.Add(3*time.Minute), now) || jtis.contains("a", now) || len(jtis.expires) != 2 {
+		t.Fatalf("the jti expiring first should be dropped when the set is full")
+	}
+	later := now.Add(150 * time.Second)
+	if jtis.contains("b", later) || !jtis.contains("c", later) {
+		t.Fatalf("b should be expired")
+	}
+	if !jtis.add("b", later.Add(time.Minute), later) || len(jtis.expires) != 2 || len(jtis.queue) != 2 {
+		t.Fatalf("an expired jti should be evicted and may be added again")
+	}
+}
+
+func TestKeySetIndex(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	duplicates := []byte(`{"keys":[` +
+		`{"kty":"oct","kid":"shared","alg":"HS256","k":"c2VjcmV0LWE"},` +
+		`{"kty":"oct","kid":"shared","alg":"HS384","k":"c2VjcmV0LWI"}]}`)
+	unique := []byte(`{"keys":[{"kty":"oct","kid":"shared","alg":"HS256","k":"c2VjcmV0LWE"}]}`)
+	index := newKeySetIndex(json.Unmarshal)
+
+	// Act & Assert
+	index.update("https://example.com/jwks.json", unique)
+	if _, ok := index.lookup("shared"); ok {
+		t.Fatalf("a kid published once should not be indexed")
+	}
+	index.update("https://example.com/jwks.json", duplicates)
+	if algs, ok := index.lookup("shared"); !ok || len(algs) != 2 {
+		t.Fatalf("a kid published twice should be indexed by alg, got %v", algs)
+	}
+	// A refresh dropping the duplicate drops the indexed keys.
+	index.update("https://example.com/jwks.json", unique)
+	if _, ok := index.lookup("shared"); ok {
+		t.Fatalf("the keys of a refreshed JWK Set should replace the indexed ones")
+	}
+	index.update("https://example.com/jwks.json", duplicates)
+	index.remove("https://example.com/jwks.json")
+	if _, ok := index.lookup("shared"); ok {
+		t.Fatalf("the keys of a removed JWK Set should not be indexed")
+	}
+}
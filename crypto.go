@@ -1,5 +1,21 @@
 package jwtware
 
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWTCurve is returned when the curve of an ECDSA key does not match the algorithm in the JWT header.
+	ErrJWTCurve = newStatusError(fiber.StatusUnauthorized, "the ECDSA key curve does not match the JWT algorithm")
+)
+
 const (
 	// HS256 represents a public cryptography key generated by a 256 bit HMAC algorithm.
 	HS256 = "HS256"
@@ -19,6 +35,10 @@ const (
 	// ES512 represents a public cryptography key generated by a 512 bit ECDSA algorithm.
 	ES512 = "ES512"
 
+	// ES256K represents a public cryptography key generated by a 256 bit ECDSA algorithm on the secp256k1 curve.
+	// See SigningMethodES256K and Secp256k1Curve.
+	ES256K = "ES256K"
+
 	// P256 represents a cryptographic elliptical curve type.
 	P256 = "P-256"
 
@@ -28,6 +48,9 @@ const (
 	// P521 represents a cryptographic elliptical curve type.
 	P521 = "P-521"
 
+	// Secp256k1 represents the cryptographic elliptical curve type used by ES256K.
+	Secp256k1 = "secp256k1"
+
 	// RS256 represents a public cryptography key generated by a 256 bit RSA algorithm.
 	RS256 = "RS256"
 
@@ -46,3 +69,64 @@ const (
 	// PS512 represents a public cryptography key generated by a 512 bit RSA algorithm.
 	PS512 = "PS512"
 )
+
+// curveForAlg maps the ECDSA algorithms to the curve their keys must use.
+var curveForAlg = map[string]string{
+	ES256:  P256,
+	ES384:  P384,
+	ES512:  P521,
+	ES256K: Secp256k1,
+}
+
+// keyCheckKeyFunc wraps the given jwt.Keyfunc and rejects keys inconsistent with the algorithm in the JWT
+// header: keys of another type than the algorithm family requires, e.g. an RSA public key for an HS256 token,
+// which would otherwise allow algorithm confusion attacks, and ECDSA keys on the wrong curve, e.g. a P-384 key
+// for an ES256 token. Keys of unknown types are left to the signing method.
+func keyCheckKeyFunc(keyFunc jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		key, err := keyFunc(token)
+		if err != nil {
+			return nil, err
+		}
+		alg, _ := token.Header["alg"].(string)
+		if err = checkKeyType(alg, key); err != nil {
+			return nil, err
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return key, nil
+		}
+		expected, ok := curveForAlg[alg]
+		if !ok {
+			return key, nil
+		}
+		if curve := ecKey.Curve.Params().Name; curve != expected {
+			return nil, fmt.Errorf("%w: %s requires a %s key, got %s", ErrJWTCurve, alg, expected, curve)
+		}
+		return key, nil
+	}
+}
+
+// checkKeyType checks that the key type is consistent with the algorithm family.
+func checkKeyType(alg string, key interface{}) error {
+	var family string
+	switch key.(type) {
+	case []byte:
+		family = "HS"
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "PS") {
+			return nil
+		}
+		family = "RS"
+	case *ecdsa.PublicKey:
+		family = "ES"
+	case ed25519.PublicKey:
+		family = "EdDSA"
+	default:
+		return nil
+	}
+	if !strings.HasPrefix(alg, family) {
+		return fmt.Errorf("%w: a %T key cannot verify %q tokens", ErrJWTAlg, key, alg)
+	}
+	return nil
+}
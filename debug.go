@@ -0,0 +1,90 @@
+package jwtware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DebugResult describes how a middleware with a given configuration handles a token. It only holds
+// public metadata, never key material.
+type DebugResult struct {
+	// Header is the decoded JOSE header of the token, nil if it could not be decoded.
+	Header map[string]interface{}
+	// KeyPath is the key source the token is verified with, e.g. "JWKSetURLs", "SigningKey" or
+	// "SigningKey.JWK".
+	KeyPath string
+	// KIDKnown is false if no key is known for the "kid" header of the token. A known key may still be
+	// unusable, e.g. for another algorithm, which is reported by Error.
+	KIDKnown bool
+	// Error is the reason the token is rejected, nil if it is accepted.
+	Error error
+}
+
+// Debug verifies the given token as a middleware with this configuration would and reports why it is
+// rejected, e.g. for an internal debug endpoint. Request specific checks such as DPoP are not performed.
+// It builds the complete configuration on every call, which fetches JWK Sets, so it is not meant for
+// the request hot path.
+func (cfg Config) Debug(tokenString string) DebugResult {
+	keyPath := cfg.keyPath()
+	cfg = makeCfg([]Config{cfg})
+	defer closeConfig(&cfg)
+	v := newVerifier(&cfg)
+
+	result := DebugResult{KeyPath: keyPath}
+	signed, err := v.signedJWT(tokenString)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	token, _, err := v.parser.ParseUnverified(signed, v.newClaims())
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.Header = token.Header
+	if _, ok := token.Header["jku"]; ok && cfg.jku != nil {
+		result.KeyPath = "AllowedJKUHosts"
+	}
+	_, err = cfg.KeyFunc(token)
+	result.KIDKnown = !errors.Is(err, keyfunc.ErrKIDNotFound)
+	_, result.Error = v.verify(context.Background(), signed)
+	return result
+}
+
+// DecodeUnverified decodes the claims of the given compact JWT into claims WITHOUT verifying its signature
+// or validating its claims, e.g. to preview a token in an admin UI. Anyone can forge the decoded claims, so
+// they must never be used for authentication or authorization decisions; use ParseAndValidate for that.
+// Encrypted tokens are not supported.
+func DecodeUnverified(tokenString string, claims jwt.Claims) error {
+	_, _, err := jwt.NewParser().ParseUnverified(tokenString, claims)
+	return err
+}
+
+// keyPath returns the key source used by the configuration, following the order of precedence, or "" if
+// there is none.
+func (cfg *Config) keyPath() string {
+	switch {
+	case cfg.KeyFunc != nil:
+		return "KeyFunc"
+	case cfg.SigningKeyResolver != nil:
+		return "SigningKeyResolver"
+	case len(cfg.JWKSetURLs) > 0:
+		return "JWKSetURLs"
+	case cfg.KeyProvider != nil:
+		return "KeyProvider"
+	case len(cfg.JWKSetJSON) > 0:
+		return "JWKSetJSON"
+	case len(cfg.SigningKeys) > 0:
+		return "SigningKeys"
+	case cfg.SigningKey.Key != nil:
+		return "SigningKey"
+	case len(cfg.SigningKey.JWK) > 0:
+		return "SigningKey.JWK"
+	case len(cfg.AllowedJKUHosts) > 0:
+		return "AllowedJKUHosts"
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package jwtware
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	dpopHeader     = "DPoP"
+	dpopTokenType  = "dpop+jwt"
+	dpopAuthScheme = "DPoP"
+	// dpopProofMaxAge is the time a DPoP proof is accepted after it was issued, and the time its "jti" is
+	// remembered to detect replays.
+	dpopProofMaxAge = 5 * time.Minute
+	// dpopMaxSeen is the maximum number of "jti"s remembered. When reached, the one remembered first is dropped.
+	dpopMaxSeen = 100000
+)
+
+var (
+	// ErrDPoPProofMissing is returned when DPoP is enabled and the request has no DPoP proof.
+	ErrDPoPProofMissing = newStatusError(fiber.StatusUnauthorized, "missing DPoP proof")
+
+	// ErrDPoPProofInvalid is returned when the DPoP proof is malformed, badly signed, or does not match the request.
+	ErrDPoPProofInvalid = newStatusError(fiber.StatusUnauthorized, "invalid DPoP proof")
+
+	// ErrDPoPProofReplayed is returned when the "jti" of a DPoP proof was already used.
+	ErrDPoPProofReplayed = newStatusError(fiber.StatusUnauthorized, "the DPoP proof was already used")
+
+	// ErrDPoPBindingMismatch is returned when the DPoP proof key does not match the "cnf.jkt" claim of the JWT.
+	ErrDPoPBindingMismatch = newStatusError(fiber.StatusUnauthorized, "the DPoP proof key does not match the JWT")
+)
+
+// dpopVerifier verifies DPoP proofs (RFC 9449) and remembers their "jti" to detect replays.
+type dpopVerifier struct {
+	parser *jwt.Parser
+	now    func() time.Time
+
+	mux  sync.Mutex
+	seen *jtiSet
+}
+
+func newDPoPVerifier(leeway time.Duration, now func() time.Time) *dpopVerifier {
+	return &dpopVerifier{
+		parser: jwt.NewParser(jwt.WithIssuedAt(), jwt.WithLeeway(leeway), jwt.WithTimeFunc(now)),
+		now:    now,
+		seen:   newJTISet(dpopMaxSeen),
+	}
+}
+
+// verify checks the DPoP proof of the request against the request and the given access token.
+func (d *dpopVerifier) verify(c *fiber.Ctx, accessToken string, token *jwt.Token) error {
+	proof := c.Get(dpopHeader)
+	if proof == "" {
+		return ErrDPoPProofMissing
+	}
+
+	var thumbprint string
+	claims := jwt.MapClaims{}
+	_, err := d.parser.ParseWithClaims(proof, claims, keyCheckKeyFunc(func(proof *jwt.Token) (interface{}, error) {
+		if typ, _ := proof.Header["typ"].(string); !strings.EqualFold(typ, dpopTokenType) {
+			return nil, fmt.Errorf("unexpected type %q", typ)
+		}
+		raw, err := json.Marshal(proof.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		var jwk jose.JSONWebKey
+		if err = jwk.UnmarshalJSON(raw); err != nil {
+			return nil, err
+		}
+		if !jwk.Valid() || !jwk.IsPublic() {
+			return nil, errors.New(`the "jwk" header must be a public key`)
+		}
+		sum, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		thumbprint = base64.RawURLEncoding.EncodeToString(sum)
+		return jwk.Key, nil
+	}))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrDPoPProofInvalid, err)
+	}
+
+	if err = checkDPoPClaims(c, claims, accessToken, d.now()); err != nil {
+		return fmt.Errorf("%w: %s", ErrDPoPProofInvalid, err)
+	}
+	cnf, _ := claimValue(token.Claims, "cnf").(map[string]interface{})
+	if jkt, _ := cnf["jkt"].(string); jkt != thumbprint {
+		return ErrDPoPBindingMismatch
+	}
+	if !d.remember(claims["jti"].(string)) {
+		return ErrDPoPProofReplayed
+	}
+	return nil
+}
+
+// checkDPoPClaims checks the claims of a DPoP proof against the request and the access token.
+func checkDPoPClaims(c *fiber.Ctx, claims jwt.MapClaims, accessToken string, now time.Time) error {
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return errors.New(`missing "jti" claim`)
+	}
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil {
+		return errors.New(`missing "iat" claim`)
+	}
+	if now.Sub(iat.Time) > dpopProofMaxAge {
+		return errors.New("the proof is too old")
+	}
+	if htm, _ := claims["htm"].(string); htm != c.Method() {
+		return fmt.Errorf("the proof is for method %q", htm)
+	}
+	htu, _ := claims["htu"].(string)
+	if !sameDPoPURL(htu, c.BaseURL()+c.Path()) {
+		return fmt.Errorf("the proof is for URL %q", htu)
+	}
+	sum := sha256.Sum256([]byte(accessToken))
+	if ath, _ := claims["ath"].(string); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return errors.New(`the "ath" claim does not match the access token`)
+	}
+	return nil
+}
+
+// sameDPoPURL compares the "htu" claim with the request URL, ignoring the query, the fragment and the case
+// of the scheme and host.
+func sameDPoPURL(htu, requestURL string) bool {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	r, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, r.Scheme) && strings.EqualFold(u.Host, r.Host) && u.Path == r.Path
+}
+
+// remember records the given "jti" and reports whether it was not seen within dpopProofMaxAge.
+func (d *dpopVerifier) remember(jti string) bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	now := d.now()
+	return d.seen.add(jti, now.Add(dpopProofMaxAge), now)
+}
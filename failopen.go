@@ -0,0 +1,42 @@
+package jwtware
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+)
+
+// keyHealth tracks the JWK Sets whose latest refresh failed, to tell a key which is unavailable during an
+// outage of the JWK Set provider from a key which does not exist.
+type keyHealth struct {
+	mux     sync.Mutex
+	failing map[string]struct{}
+}
+
+func newKeyHealth() *keyHealth {
+	return &keyHealth{failing: make(map[string]struct{})}
+}
+
+// record is called after every refresh of the JWK Set at the given URL.
+func (h *keyHealth) record(url string, err error, _ time.Duration) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if err != nil {
+		h.failing[url] = struct{}{}
+	} else {
+		delete(h.failing, url)
+	}
+}
+
+// keyUnavailable reports whether the verification failed because no key was found for the token while
+// a JWK Set could not be refreshed, as opposed to e.g. a signature mismatch.
+func (h *keyHealth) keyUnavailable(err error) bool {
+	if !errors.Is(err, keyfunc.ErrKIDNotFound) {
+		return false
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return len(h.failing) > 0
+}
@@ -6,8 +6,12 @@ go 1.18
 
 require (
 	github.com/MicahParks/keyfunc/v2 v2.0.3
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0
+	github.com/go-jose/go-jose/v3 v3.0.5
 	github.com/gofiber/fiber/v2 v2.46.0
 	github.com/golang-jwt/jwt/v5 v5.0.0
+	github.com/valyala/fasthttp v1.47.0
+	golang.org/x/crypto v0.19.0
 )
 
 require (
@@ -23,7 +27,6 @@ require (
 	github.com/savsgio/gotils v0.0.0-20230208104028-c358bd845dee // indirect
 	github.com/tinylib/msgp v1.1.8 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	github.com/valyala/fasthttp v1.47.0 // indirect
 	github.com/valyala/tcplisten v1.0.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
 )
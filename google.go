@@ -0,0 +1,59 @@
+package jwtware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// googleJWKSetURL serves the keys Google signs ID tokens with.
+	googleJWKSetURL = "https://www.googleapis.com/oauth2/v3/certs"
+	// firebaseJWKSetURL serves the keys Firebase Authentication signs ID tokens with.
+	firebaseJWKSetURL = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+	// firebaseIssuerPrefix is followed by the project ID in the "iss" claim of Firebase ID tokens.
+	firebaseIssuerPrefix = "https://securetoken.google.com/"
+)
+
+// NewGoogle returns a middleware verifying Google ID tokens issued to the given OAuth client ID.
+// See GoogleConfig for the checks.
+func NewGoogle(audience string) fiber.Handler {
+	return New(GoogleConfig(audience))
+}
+
+// GoogleConfig returns the Config verifying Google ID tokens issued to the given OAuth client ID, e.g. to
+// complement it before calling New. Tokens are verified with the keys published by Google, and must carry
+// the client ID in the "aud" claim, Google in the "iss" claim, and an "exp" claim. It panics if the audience
+// is empty.
+func GoogleConfig(audience string) Config {
+	if audience == "" {
+		panic("Fiber: JWT middleware configuration: The audience of Google ID tokens is required.")
+	}
+	return Config{
+		JWKSetURLs:        []string{googleJWKSetURL},
+		ExpectedAudience:  audience,
+		ExpectedIssuers:   []string{"https://accounts.google.com", "accounts.google.com"},
+		RequireExpiration: true,
+	}
+}
+
+// NewFirebase returns a middleware verifying Firebase Authentication ID tokens of the given project.
+// See FirebaseConfig for the checks.
+func NewFirebase(projectID string) fiber.Handler {
+	return New(FirebaseConfig(projectID))
+}
+
+// FirebaseConfig returns the Config verifying Firebase Authentication ID tokens of the given project, e.g. to
+// complement it before calling New. Tokens are verified with the keys published by Firebase, and must carry
+// the project ID in the "aud" claim, the project in the "iss" claim, a non-empty "sub" claim and an "exp"
+// claim. It panics if the project ID is empty.
+func FirebaseConfig(projectID string) Config {
+	if projectID == "" {
+		panic("Fiber: JWT middleware configuration: The project ID of Firebase ID tokens is required.")
+	}
+	return Config{
+		JWKSetURLs:        []string{firebaseJWKSetURL},
+		ExpectedAudience:  projectID,
+		ExpectedIssuers:   []string{firebaseIssuerPrefix + projectID},
+		RequireExpiration: true,
+		RequireSubject:    true,
+	}
+}
@@ -0,0 +1,82 @@
+package jwtware
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrKeyDerivation is returned when the KeyDerivation of a SigningKey is invalid.
+var ErrKeyDerivation = errors.New("invalid key derivation")
+
+// KeyDerivation derives the HMAC key of a SigningKey from the master secret in its Key using HKDF (RFC 5869).
+type KeyDerivation struct {
+	// Hash is the hash function of HKDF: "SHA-256", "SHA-384" or "SHA-512".
+	// Optional. Default: "SHA-256"
+	Hash string
+	// Salt of HKDF, e.g. per service.
+	// Optional. Default: nil
+	Salt []byte
+	// Info of HKDF, binding the derived key to its purpose.
+	// Optional. Default: nil
+	Info []byte
+	// Length of the derived key in bytes. It must be at least the output size of the hash of the JWTAlg of
+	// the SigningKey, as RFC 7518 requires for HMAC keys.
+	// Optional. Default: the output size of Hash.
+	Length int
+}
+
+var keyDerivationHashes = map[string]crypto.Hash{
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+var hmacKeySizes = map[string]int{
+	HS256: 32,
+	HS384: 48,
+	HS512: 64,
+}
+
+// derive returns the signing key with the key derived from its master secret, or the signing key itself
+// if it has no KeyDerivation.
+func (key SigningKey) derive() (SigningKey, error) {
+	d := key.KeyDerivation
+	if d == nil {
+		return key, nil
+	}
+	secret, ok := key.Key.([]byte)
+	if !ok || len(secret) == 0 {
+		return key, fmt.Errorf("%w: the master secret must be a non-empty []byte", ErrKeyDerivation)
+	}
+	minLength, ok := hmacKeySizes[key.JWTAlg]
+	if !ok && key.JWTAlg != "" {
+		return key, fmt.Errorf("%w: %q is not an HMAC algorithm", ErrKeyDerivation, key.JWTAlg)
+	}
+	hashName := d.Hash
+	if hashName == "" {
+		hashName = "SHA-256"
+	}
+	hash, ok := keyDerivationHashes[hashName]
+	if !ok {
+		return key, fmt.Errorf("%w: unsupported hash %q", ErrKeyDerivation, d.Hash)
+	}
+	length := d.Length
+	if length == 0 {
+		length = hash.Size()
+	}
+	if length < minLength || length > 255*hash.Size() {
+		return key, fmt.Errorf("%w: length %d must be between %d and %d", ErrKeyDerivation, length, minLength, 255*hash.Size())
+	}
+
+	derived := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(hash.New, secret, d.Salt, d.Info), derived); err != nil {
+		return key, fmt.Errorf("%w: %s", ErrKeyDerivation, err)
+	}
+	key.Key = derived
+	key.KeyDerivation = nil
+	return key, nil
+}
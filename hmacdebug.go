@@ -0,0 +1,73 @@
+package jwtware
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// hmacKeyHints returns a hint about the encoding of every HMAC key, keyed by its key ID and "" for
+// SigningKey. They are computed once, so that logging a failure does not depend on the secret.
+func hmacKeyHints(signingKey SigningKey, signingKeys map[string]SigningKey) map[string]string {
+	hints := make(map[string]string, len(signingKeys)+1)
+	if secret, ok := signingKey.Key.([]byte); ok {
+		hints[""] = hmacKeyHint(secret)
+	}
+	for kid, key := range signingKeys {
+		if secret, ok := key.Key.([]byte); ok {
+			hints[kid] = hmacKeyHint(secret)
+		}
+	}
+	return hints
+}
+
+// hmacKeyHint describes the configured secret without revealing it: its length, and whether it may be the
+// text encoding of a secret instead of the secret itself, which is the most common cause of mismatches.
+func hmacKeyHint(secret []byte) string {
+	hint := fmt.Sprintf("the configured secret has %d bytes", len(secret))
+	text := strings.TrimRight(string(secret), "=")
+	switch {
+	case len(text) >= 32 && len(text)%2 == 0 && isDecodable(hex.DecodeString, text):
+		hint += " and may be hex encoded; configure the decoded bytes if the issuer signs with them"
+	case len(text) >= 22 && (isDecodable(base64.RawStdEncoding.DecodeString, text) || isDecodable(base64.RawURLEncoding.DecodeString, text)):
+		hint += " and may be base64 encoded; configure the decoded bytes if the issuer signs with them"
+	}
+	return hint
+}
+
+func isDecodable(decode func(string) ([]byte, error), s string) bool {
+	_, err := decode(s)
+	return err == nil
+}
+
+// logHMACFailure logs at debug level whether an HMAC token failed on its structure or on its signature.
+// It runs after the verification failed and does not change the response, so it adds no timing side
+// channel beyond the failure itself.
+func (cfg *Config) logHMACFailure(signed string, err error) {
+	token, _, perr := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if perr != nil {
+		cfg.Logger.Printf("DEBUG: JWT verification failed on the token structure: %s.", perr)
+		return
+	}
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return
+	}
+	kid, _ := token.Header["kid"].(string)
+	switch {
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		hint, ok := cfg.hmacHints[kid]
+		if !ok {
+			hint, ok = cfg.hmacHints[""]
+		}
+		if !ok {
+			hint = "the key was not configured as []byte"
+		}
+		cfg.Logger.Printf("DEBUG: %s JWT verification failed on the signature, %s.", token.Method.Alg(), hint)
+	case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, jwt.ErrTokenUnverifiable):
+		cfg.Logger.Printf("DEBUG: %s JWT verification failed on the token structure or key lookup: %s.", token.Method.Alg(), err)
+	}
+}
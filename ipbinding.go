@@ -0,0 +1,33 @@
+package jwtware
+
+import (
+	"net"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWTClientIPMissing is returned when client IP validation is enabled and the JWT has no valid
+	// "cnf.ip" claim.
+	ErrJWTClientIPMissing = newStatusError(fiber.StatusUnauthorized, "the JWT \"cnf.ip\" claim is missing or invalid")
+
+	// ErrJWTClientIPMismatch is returned when the "cnf.ip" claim of the JWT does not match the client IP.
+	ErrJWTClientIPMismatch = newStatusError(fiber.StatusUnauthorized, "the JWT is not bound to the client IP")
+)
+
+// validateClientIP checks that the "cnf.ip" claim of the token is the IP of the client, as reported by
+// c.IP(). Addresses are compared after parsing, so that e.g. an IPv4 address matches its IPv4-mapped
+// IPv6 form and differently abbreviated IPv6 addresses match.
+func validateClientIP(c *fiber.Ctx, token *jwt.Token) error {
+	cnf, _ := claimValue(token.Claims, "cnf").(map[string]interface{})
+	claimed, _ := cnf["ip"].(string)
+	ip := net.ParseIP(claimed)
+	if ip == nil {
+		return ErrJWTClientIPMissing
+	}
+	if !ip.Equal(net.ParseIP(c.IP())) {
+		return ErrJWTClientIPMismatch
+	}
+	return nil
+}
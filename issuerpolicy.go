@@ -0,0 +1,156 @@
+package jwtware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IssuerPolicy holds the validation rules for the tokens of one issuer, see Config.IssuerPolicies.
+type IssuerPolicy struct {
+	// Audience requires the "aud" claim to contain the given audience. Tokens for another audience are
+	// rejected with an error wrapping jwt.ErrTokenInvalidAudience.
+	// Optional. Default: "", the audience is not checked.
+	Audience string
+
+	// RequiredClaims lists the claims the tokens must have. Tokens without one of them are rejected with an
+	// error wrapping jwt.ErrTokenRequiredClaimMissing.
+	// Optional. Default: nil
+	RequiredClaims []string
+
+	// Algorithms lists the algorithms the tokens may be signed with, e.g. []string{RS256}. Tokens signed with
+	// another algorithm are rejected with an error wrapping ErrJWTAlg.
+	// Optional. Default: nil, every algorithm accepted by the keys is allowed.
+	Algorithms []string
+
+	// SigningKeys are the keys of the issuer by "kid". If SigningKeys or JWKSetURLs is set, the tokens of the
+	// issuer are only verified with these keys, so that a token signed with the key of another issuer cannot
+	// claim to be issued by this one. Otherwise, the issuer shares the key sources of the configuration with
+	// all other issuers without key sources of their own.
+	// Optional. Default: nil
+	SigningKeys map[string]SigningKey
+
+	// JWKSetURLs are the JWK Sets of the issuer, see SigningKeys. They must be listed in Config.JWKSetURLs,
+	// which fetches and refreshes them.
+	// Optional. Default: nil
+	JWKSetURLs []string
+}
+
+// issuerKeys are the key sources of an IssuerPolicy.
+type issuerKeys struct {
+	given *keyfunc.JWKS
+	urls  []string
+}
+
+// issuerKeySources resolves the key sources of the IssuerPolicies, by issuer.
+func (cfg *Config) issuerKeySources() map[string]issuerKeys {
+	issuers := make(map[string]issuerKeys)
+	for iss, policy := range cfg.IssuerPolicies {
+		if len(policy.SigningKeys) == 0 && len(policy.JWKSetURLs) == 0 {
+			continue
+		}
+		keys := issuerKeys{urls: policy.JWKSetURLs}
+		if len(policy.SigningKeys) > 0 {
+			givenKeys := make(map[string]keyfunc.GivenKey, len(policy.SigningKeys))
+			for kid, key := range policy.SigningKeys {
+				key = key.mustResolve()
+				givenKeys[kid] = keyfunc.NewGivenCustom(key.Key, keyfunc.GivenKeyOptions{Algorithm: key.JWTAlg})
+			}
+			keys.given = keyfunc.NewGiven(givenKeys)
+		}
+		for _, url := range policy.JWKSetURLs {
+			if cfg.jwks == nil || !containsString(cfg.JWKSetURLs, url) {
+				panic(fmt.Sprintf("Fiber: JWT middleware configuration: the JWK Set URL %q of issuer %q must be listed in JWKSetURLs.", url, iss))
+			}
+		}
+		issuers[iss] = keys
+	}
+	return issuers
+}
+
+// issuerKeyfunc returns a jwt.Keyfunc verifying the tokens of issuers with key sources of their own only with
+// those, falling back to next for the tokens of other issuers. The "iss" claim is not verified yet, but a token
+// claiming another issuer fails verification with the keys of that issuer.
+func issuerKeyfunc(issuers map[string]issuerKeys, multiJWKS *keyfunc.MultipleJWKS, index *keySetIndex, next jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		iss, _ := token.Claims.GetIssuer()
+		keys, ok := issuers[iss]
+		if !ok {
+			return next(token)
+		}
+		var lastErr error = keyfunc.ErrKIDNotFound
+		if keys.given != nil {
+			key, err := keys.given.Keyfunc(token)
+			if err == nil || !errors.Is(err, keyfunc.ErrKIDNotFound) {
+				return key, err
+			}
+		}
+		kid, _ := token.Header["kid"].(string)
+		for _, url := range keys.urls {
+			if algs, ok := index.lookupSource(url, kid); ok {
+				return kidAlgKey(token, kid, algs)
+			}
+			jwks, ok := multiJWKS.JWKSets()[url]
+			if !ok {
+				continue
+			}
+			key, err := jwks.Keyfunc(token)
+			if err == nil {
+				return key, nil
+			}
+			lastErr = err
+		}
+		return nil, fmt.Errorf("no key of issuer %q: %w", iss, lastErr)
+	}
+}
+
+// validateIssuerPolicy applies the policy of the issuer of the token, rejecting issuers without a policy.
+func (cfg *Config) validateIssuerPolicy(token *jwt.Token) error {
+	iss, err := token.Claims.GetIssuer()
+	if err != nil {
+		return err
+	}
+	policy, ok := cfg.IssuerPolicies[iss]
+	if !ok {
+		return fmt.Errorf("%w: no policy for issuer %q", jwt.ErrTokenInvalidIssuer, iss)
+	}
+	if len(policy.Algorithms) > 0 && !containsString(policy.Algorithms, token.Method.Alg()) {
+		return fmt.Errorf("%w: %q is not allowed for issuer %q", ErrJWTAlg, token.Method.Alg(), iss)
+	}
+	if policy.Audience != "" {
+		aud, err := token.Claims.GetAudience()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, a := range aud {
+			if subtle.ConstantTimeCompare([]byte(a), []byte(policy.Audience)) == 1 {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: the audience of issuer %q is %q", jwt.ErrTokenInvalidAudience, iss, policy.Audience)
+		}
+	}
+	if len(policy.RequiredClaims) > 0 {
+		claims := claimsMap(token.Claims, cfg.JSONUnmarshal)
+		for _, name := range policy.RequiredClaims {
+			if claims[name] == nil {
+				return fmt.Errorf("%w: %s claim is required for issuer %q", jwt.ErrTokenRequiredClaimMissing, name, iss)
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package jwtware
+
+import (
+	"container/heap"
+	"time"
+)
+
+// jtiSet records "jti" claims until they expire, holding at most size of them. Expired entries are evicted in
+// the order of their expiry, so that recording one costs O(log n) rather than a sweep over all entries.
+// It is not safe for concurrent use.
+type jtiSet struct {
+	size    int
+	expires map[string]time.Time
+	queue   jtiQueue
+}
+
+func newJTISet(size int) *jtiSet {
+	return &jtiSet{size: size, expires: make(map[string]time.Time)}
+}
+
+// contains reports whether the jti is recorded and not expired at now.
+func (s *jtiSet) contains(jti string, now time.Time) bool {
+	expires, ok := s.expires[jti]
+	return ok && now.Before(expires)
+}
+
+// add records the jti until expires and reports whether it was not recorded yet. Expired entries are evicted
+// first. If the set is still full, the entry expiring first is dropped.
+func (s *jtiSet) add(jti string, expires, now time.Time) bool {
+	s.evict(now)
+	if s.contains(jti, now) {
+		return false
+	}
+	if len(s.expires) >= s.size {
+		s.pop()
+	}
+	s.expires[jti] = expires
+	heap.Push(&s.queue, jtiEntry{jti: jti, expires: expires})
+	return true
+}
+
+// evict drops the entries expired at now.
+func (s *jtiSet) evict(now time.Time) {
+	for len(s.queue) > 0 && !now.Before(s.queue[0].expires) {
+		s.pop()
+	}
+}
+
+// pop drops the entry expiring first. Queue entries of a jti which was recorded again are skipped.
+func (s *jtiSet) pop() {
+	for len(s.queue) > 0 {
+		entry := heap.Pop(&s.queue).(jtiEntry)
+		if expires, ok := s.expires[entry.jti]; ok && expires.Equal(entry.expires) {
+			delete(s.expires, entry.jti)
+			return
+		}
+	}
+}
+
+type jtiEntry struct {
+	jti     string
+	expires time.Time
+}
+
+// jtiQueue is a heap of entries ordered by expiry.
+type jtiQueue []jtiEntry
+
+func (q jtiQueue) Len() int           { return len(q) }
+func (q jtiQueue) Less(i, j int) bool { return q[i].expires.Before(q[j].expires) }
+func (q jtiQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *jtiQueue) Push(x interface{}) {
+	*q = append(*q, x.(jtiEntry))
+}
+
+func (q *jtiQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,75 @@
+package jwtware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// ErrJWEDecryptionKey is returned when an encrypted JWT (JWE) is received but no decryption key is configured.
+	ErrJWEDecryptionKey = newStatusError(fiber.StatusUnauthorized, "received an encrypted JWT, but no decryption key is configured")
+
+	// ErrJWEDecrypt is returned when an encrypted JWT (JWE) could not be decrypted.
+	ErrJWEDecrypt = newStatusError(fiber.StatusUnauthorized, "failed to decrypt JWT")
+
+	// ErrJWEAlgorithm is returned when an encrypted JWT (JWE) uses an algorithm which is not accepted,
+	// see DecryptionAlgorithms and DecryptionEncryptions.
+	ErrJWEAlgorithm = newStatusError(fiber.StatusUnauthorized, "the encrypted JWT uses an algorithm which is not accepted")
+)
+
+var (
+	// defaultDecryptionAlgorithms are the key management algorithms accepted by default. RSA1_5 is open to
+	// padding oracle attacks, and PBES2 lets the sender choose the iteration count, i.e. the cost of decrypting.
+	defaultDecryptionAlgorithms = []string{
+		string(jose.RSA_OAEP), string(jose.RSA_OAEP_256),
+		string(jose.ECDH_ES), string(jose.ECDH_ES_A128KW), string(jose.ECDH_ES_A192KW), string(jose.ECDH_ES_A256KW),
+		string(jose.DIRECT),
+	}
+	// defaultDecryptionEncryptions are the content encryption algorithms accepted by default.
+	defaultDecryptionEncryptions = []string{string(jose.A128GCM), string(jose.A192GCM), string(jose.A256GCM)}
+)
+
+// isJWE reports whether the given compact token is an encrypted JWT (JWE), which has five segments.
+func isJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// decryptJWE decrypts the given compact JWE and returns its plaintext, the inner signed JWT. The algorithms of
+// its header are checked against the given ones before decrypting it.
+func decryptJWE(token string, key interface{}, algs, encs []string) (string, error) {
+	if key == nil {
+		return "", ErrJWEDecryptionKey
+	}
+	// The header of a compact JWE is its first segment, and is protected as a whole.
+	segment, err := base64.RawURLEncoding.DecodeString(token[:strings.IndexByte(token, '.')])
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrJWEDecrypt, err)
+	}
+	var header struct {
+		Algorithm  string `json:"alg"`
+		Encryption string `json:"enc"`
+	}
+	if err = json.Unmarshal(segment, &header); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrJWEDecrypt, err)
+	}
+	if !containsString(algs, header.Algorithm) {
+		return "", fmt.Errorf("%w: %q", ErrJWEAlgorithm, header.Algorithm)
+	}
+	if !containsString(encs, header.Encryption) {
+		return "", fmt.Errorf("%w: %q", ErrJWEAlgorithm, header.Encryption)
+	}
+	jwe, err := jose.ParseEncrypted(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrJWEDecrypt, err)
+	}
+	plaintext, err := jwe.Decrypt(key)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrJWEDecrypt, err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,85 @@
+package jwtware
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/MicahParks/keyfunc/v2"
+)
+
+// ErrJWK is returned when the JWK of a SigningKey cannot be parsed.
+var ErrJWK = errors.New("invalid JWK")
+
+// parseJWK returns the signing key with Key parsed from its JWK, or the signing key itself if it has no JWK.
+// The JWK is parsed like the keys of JWK Sets, so the "kty" values RSA, EC, OKP and oct are supported, EC
+// including the secp256k1 curve.
+func (key SigningKey) parseJWK() (SigningKey, error) {
+	if len(key.JWK) == 0 {
+		return key, nil
+	}
+	if key.Key != nil {
+		return key, fmt.Errorf("%w: Key and JWK are mutually exclusive", ErrJWK)
+	}
+	var header secp256k1JWK
+	if err := json.Unmarshal(key.JWK, &header); err != nil {
+		return key, fmt.Errorf("%w: %s", ErrJWK, err)
+	}
+	if header.Curve == Secp256k1 {
+		public, err := parseSecp256k1JWK(header)
+		if err != nil {
+			return key, err
+		}
+		key.Key = public
+		if key.JWTAlg == "" {
+			key.JWTAlg = ES256K
+		}
+		key.JWK = nil
+		return key, nil
+	}
+	keySet, err := json.Marshal(map[string][]json.RawMessage{"keys": {key.JWK}})
+	if err != nil {
+		return key, fmt.Errorf("%w: %s", ErrJWK, err)
+	}
+	jwks, err := keyfunc.NewJSON(keySet)
+	if err != nil {
+		return key, fmt.Errorf("%w: %s", ErrJWK, err)
+	}
+	// NewJSON skips keys it cannot parse, so the JWK was parsed if there is a key.
+	for _, parsed := range jwks.ReadOnlyKeys() {
+		key.Key = parsed
+	}
+	if key.Key == nil {
+		return key, fmt.Errorf("%w: unsupported \"kty\" or malformed key parameters", ErrJWK)
+	}
+	if ec, ok := key.Key.(*ecdsa.PublicKey); ok && !ec.Curve.IsOnCurve(ec.X, ec.Y) {
+		return key, fmt.Errorf("%w: the point is not on the curve", ErrJWK)
+	}
+	if key.JWTAlg == "" {
+		key.JWTAlg = header.Algorithm
+	}
+	key.JWK = nil
+	return key, nil
+}
+
+// resolve returns the signing key with its JWK parsed and its key derived, see parseJWK and derive.
+func (key SigningKey) resolve() (SigningKey, error) {
+	key, err := key.parseJWK()
+	if err != nil {
+		return key, err
+	}
+	if key.JWTAlg == ES256K {
+		RegisterES256K()
+	}
+	return key.derive()
+}
+
+// mustResolve is like resolve but panics with a configuration error.
+func (key SigningKey) mustResolve() SigningKey {
+	resolved, err := key.resolve()
+	if err != nil {
+		panic("Fiber: JWT middleware configuration: " + err.Error())
+	}
+	return resolved
+}
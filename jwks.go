@@ -0,0 +1,664 @@
+package jwtware
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWKSetHTTPStatus is returned when a JWK Set URL responds with a status other than 200 OK.
+	ErrJWKSetHTTPStatus = errors.New("unexpected HTTP status fetching JWK Set")
+
+	// ErrJWKSetSignature is returned when a signed JWK Set could not be verified with the trust anchor.
+	ErrJWKSetSignature = errors.New("failed to verify the signature of the JWK Set")
+
+	// ErrJWKSetNoKeys is returned when a JWK Set contains no usable key.
+	ErrJWKSetNoKeys = errors.New("JWK Set contains no usable key")
+)
+
+// multiKeySelector is the key selector signature used by keyfunc.MultipleOptions.
+type multiKeySelector = func(multiJWKS *keyfunc.MultipleJWKS, token *jwt.Token) (interface{}, error)
+
+// KeySelector chooses the key to verify the token with from the candidate keys found in the JWK Sets.
+type KeySelector func(candidates []interface{}, token *jwt.Token) (interface{}, error)
+
+// KeySelectorFirst selects the candidate key from the first JWK Set URL containing the token's "kid".
+func KeySelectorFirst(candidates []interface{}, _ *jwt.Token) (interface{}, error) {
+	return candidates[0], nil
+}
+
+// KeySelectorLast selects the candidate key from the last JWK Set URL containing the token's "kid".
+func KeySelectorLast(candidates []interface{}, _ *jwt.Token) (interface{}, error) {
+	return candidates[len(candidates)-1], nil
+}
+
+// orderedKeySelector collects the keys matching the token from every JWK Set in the order of jwkSetURLs
+// and lets the given KeySelector choose among them. Only the JWK Sets already holding the token's "kid" are
+// asked, so that the others are not refreshed for it. If none holds it, all of them are asked, which
+// refreshes them if JWKSetRefreshUnknownKID is set.
+func orderedKeySelector(jwkSetURLs []string, selector KeySelector) multiKeySelector {
+	return func(multiJWKS *keyfunc.MultipleJWKS, token *jwt.Token) (interface{}, error) {
+		sets := multiJWKS.JWKSets()
+		kid, _ := token.Header["kid"].(string)
+		ordered := make([]*keyfunc.JWKS, 0, len(jwkSetURLs))
+		var known []*keyfunc.JWKS
+		for _, url := range jwkSetURLs {
+			jwks, ok := sets[url]
+			if !ok {
+				continue
+			}
+			ordered = append(ordered, jwks)
+			if _, ok := jwks.ReadOnlyKeys()[kid]; ok {
+				known = append(known, jwks)
+			}
+		}
+		if len(known) > 0 {
+			ordered = known
+		}
+		var candidates []interface{}
+		var lastErr error
+		for _, jwks := range ordered {
+			key, err := jwks.Keyfunc(token)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			candidates = append(candidates, key)
+		}
+		if len(candidates) == 0 {
+			if lastErr == nil {
+				lastErr = keyfunc.ErrKIDNotFound
+			}
+			return nil, fmt.Errorf("failed to find key ID in multiple JWKS: %w", lastErr)
+		}
+		return selector(candidates, token)
+	}
+}
+
+// singleKeySelector falls back to the only known key when the token has no "kid" header and the JWK Sets
+// contain exactly one key. Otherwise, the given selector is used.
+func singleKeySelector(next multiKeySelector) multiKeySelector {
+	return func(multiJWKS *keyfunc.MultipleJWKS, token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Header["kid"]; ok {
+			return next(multiJWKS, token)
+		}
+		var single interface{}
+		count := 0
+		for _, jwks := range multiJWKS.JWKSets() {
+			for _, key := range jwks.ReadOnlyKeys() {
+				single = key
+				count++
+			}
+		}
+		if count != 1 {
+			return next(multiJWKS, token)
+		}
+		return single, nil
+	}
+}
+
+// validateJWKSets checks that every fetched JWK Set contains at least one key which could be parsed.
+// Given keys are not taken into account.
+func validateJWKSets(multiJWKS *keyfunc.MultipleJWKS) error {
+	for url, jwks := range multiJWKS.JWKSets() {
+		parsed, err := keyfunc.NewJSON(jwks.RawJWKS())
+		if err != nil {
+			return fmt.Errorf("failed to parse JWK Set from %s: %w", url, err)
+		}
+		if parsed.Len() == 0 {
+			return fmt.Errorf("%w: %s", ErrJWKSetNoKeys, url)
+		}
+	}
+	return nil
+}
+
+// jwksRequestFactory creates the HTTP request used to fetch a JWK Set. It explicitly asks for a gzip encoded
+// response, which is decompressed by jwksResponseExtractor.
+func jwksRequestFactory(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(fiber.HeaderAcceptEncoding, "gzip")
+	return req, nil
+}
+
+// jwksResponseExtractor reads the JWK Set from the response body, transparently decompressing it when the
+// server replied with "Content-Encoding: gzip". Responses other than 200 OK are rejected without reading
+// the body, which is usually an HTML error page.
+func jwksResponseExtractor(ctx context.Context, resp *http.Response) (json.RawMessage, error) {
+	if err := prepareJWKSResponse(resp); err != nil {
+		return nil, err
+	}
+	return keyfunc.ResponseExtractorStatusOK(ctx, resp)
+}
+
+// prepareJWKSResponse rejects responses other than 200 OK and replaces the body of gzip encoded responses
+// with the decompressed one.
+func prepareJWKSResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return fmt.Errorf("%w: got HTTP %d from %s", ErrJWKSetHTTPStatus, resp.StatusCode, resp.Request.URL)
+	}
+	if !resp.Uncompressed && strings.EqualFold(resp.Header.Get(fiber.HeaderContentEncoding), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			_ = resp.Body.Close()
+			return fmt.Errorf("failed to decompress JWK Set: %w", err)
+		}
+		resp.Body = gzipBody{Reader: gz, body: resp.Body}
+	}
+	return nil
+}
+
+// gzipBody is a decompressed response body, closing both the decompressor and the original body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b gzipBody) Close() error {
+	_ = b.Reader.Close()
+	return b.body.Close()
+}
+
+// signedJWKSResponseExtractor wraps the given extractor for JWK Set URLs serving the JWK Set as the payload
+// of a compact JWS, e.g. "signed_jwks_uri" of OpenID Federation. The JWS is verified with the trust anchor,
+// and only its payload is passed on to keyfunc.
+func signedJWKSResponseExtractor(anchor SigningKey, next func(context.Context, *http.Response) (json.RawMessage, error)) func(context.Context, *http.Response) (json.RawMessage, error) {
+	parser := jwt.NewParser()
+	keyFunc := keyCheckKeyFunc(rejectAlgNoneKeyFunc(signingKeyFunc(anchor)))
+	return func(ctx context.Context, resp *http.Response) (json.RawMessage, error) {
+		raw, err := next(ctx, resp)
+		if err != nil {
+			return nil, err
+		}
+		signed := strings.TrimSpace(string(raw))
+		if _, err = parser.Parse(signed, keyFunc); err != nil {
+			return nil, fmt.Errorf("%w from %s: %s", ErrJWKSetSignature, resp.Request.URL, err)
+		}
+		// The JWS has three segments, as it was parsed successfully.
+		payload, err := base64.RawURLEncoding.DecodeString(strings.Split(signed, ".")[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w from %s: %s", ErrJWKSetSignature, resp.Request.URL, err)
+		}
+		return payload, nil
+	}
+}
+
+// maxJKUSets is the maximum number of JWK Sets fetched from "jku" headers which are kept at the same time.
+// The least recently used one is dropped and its background refreshes stopped when another one is fetched.
+const maxJKUSets = 16
+
+// jkuKeyfunc verifies tokens with the JWK Set referenced by their "jku" header, if the host is allowed.
+type jkuKeyfunc struct {
+	allowedHosts map[string]struct{}
+	options      func(jwksURL string, givenKeys map[string]keyfunc.GivenKey) keyfunc.Options
+	// failureTTL is how long a failed fetch is remembered, so that tokens referencing the URL fail without
+	// fetching it again.
+	failureTTL time.Duration
+	// index indexes the fetched JWK Sets, see keySetIndex.
+	index *keySetIndex
+
+	mux  sync.Mutex
+	sets map[string]*jkuEntry
+}
+
+// jkuEntry is a JWK Set fetched from a "jku" header. Requests for a URL being fetched wait for that fetch.
+type jkuEntry struct {
+	ready    chan struct{}
+	jwks     *keyfunc.JWKS
+	err      error
+	failedAt time.Time
+	lastUsed time.Time
+}
+
+func newJKUKeyfunc(allowedHosts []string, failureTTL time.Duration, options func(string, map[string]keyfunc.GivenKey) keyfunc.Options, index *keySetIndex) *jkuKeyfunc {
+	j := &jkuKeyfunc{
+		allowedHosts: make(map[string]struct{}, len(allowedHosts)),
+		options:      options,
+		failureTTL:   failureTTL,
+		index:        index,
+		sets:         make(map[string]*jkuEntry),
+	}
+	for _, host := range allowedHosts {
+		j.allowedHosts[strings.ToLower(host)] = struct{}{}
+	}
+	return j
+}
+
+// keyfunc returns a jwt.Keyfunc using the JWK Set referenced by the "jku" header, falling back to next
+// without one. Tokens with a "jku" header never reach next, so that they are not verified with keys of
+// another source.
+func (j *jkuKeyfunc) keyfunc(next jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Header["jku"]; !ok {
+			return next(token)
+		}
+		return j.lookup(token)
+	}
+}
+
+// lookup returns the key of the token from the JWK Set referenced by its "jku" header.
+func (j *jkuKeyfunc) lookup(token *jwt.Token) (interface{}, error) {
+	jku, ok := token.Header["jku"].(string)
+	if !ok {
+		return nil, ErrJWTJKUNotAllowed
+	}
+	u, err := url.Parse(jku)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") || u.User != nil || u.Host == "" {
+		return nil, ErrJWTJKUNotAllowed
+	}
+	if !j.allowed(u) {
+		return nil, fmt.Errorf("%w: %q", ErrJWTJKUNotAllowed, u.Host)
+	}
+	jwksURL := normalizeJKU(u)
+	jwks, err := j.get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	// Only the keys of this JWK Set are considered, so that the "jku" header binds the key source.
+	if kid, ok := token.Header["kid"].(string); ok {
+		if algs, ok := j.index.lookupSource(jwksURL, kid); ok {
+			return kidAlgKey(token, kid, algs)
+		}
+	}
+	return jwks.Keyfunc(token)
+}
+
+func (j *jkuKeyfunc) allowed(u *url.URL) bool {
+	if _, ok := j.allowedHosts[strings.ToLower(u.Host)]; ok {
+		return true
+	}
+	_, ok := j.allowedHosts[strings.ToLower(u.Hostname())]
+	return ok
+}
+
+// normalizeJKU returns the URL the JWK Set of a "jku" header is cached under, so that spellings of the same
+// URL share one JWK Set: with lower case scheme and host, without the default port and without fragment.
+func normalizeJKU(u *url.URL) string {
+	normalized := *u
+	normalized.Scheme = "https"
+	normalized.Host = strings.ToLower(strings.TrimSuffix(u.Host, ":443"))
+	normalized.Fragment = ""
+	normalized.RawFragment = ""
+	return normalized.String()
+}
+
+// JWKSets returns a copy of the JWK Sets fetched so far, keyed by URL.
+func (j *jkuKeyfunc) JWKSets() map[string]*keyfunc.JWKS {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	sets := make(map[string]*keyfunc.JWKS, len(j.sets))
+	for u, entry := range j.sets {
+		if entry.jwks != nil {
+			sets[u] = entry.jwks
+		}
+	}
+	return sets
+}
+
+// close stops the background refreshes of the JWK Sets fetched so far and drops them from the cache.
+func (j *jkuKeyfunc) close() {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	for u, entry := range j.sets {
+		if entry.jwks != nil {
+			entry.jwks.EndBackground()
+		}
+		delete(j.sets, u)
+		j.index.remove(u)
+	}
+}
+
+// get returns the cached JWK Set for the given URL, fetching it on first use. The fetch runs without holding
+// the lock, and concurrent requests for the same URL wait for it instead of fetching the URL again.
+func (j *jkuKeyfunc) get(jwksURL string) (*keyfunc.JWKS, error) {
+	j.mux.Lock()
+	now := time.Now()
+	entry, ok := j.sets[jwksURL]
+	if ok && entry.err != nil && now.Sub(entry.failedAt) >= j.failureTTL {
+		delete(j.sets, jwksURL)
+		ok = false
+	}
+	if ok {
+		entry.lastUsed = now
+		j.mux.Unlock()
+		<-entry.ready
+		return entry.jwks, entry.err
+	}
+	entry = &jkuEntry{ready: make(chan struct{}), lastUsed: now}
+	j.evict()
+	j.sets[jwksURL] = entry
+	j.mux.Unlock()
+
+	jwks, err := keyfunc.Get(jwksURL, indexKeySet(jwksURL, j.options(jwksURL, nil), j.index))
+
+	j.mux.Lock()
+	if err != nil {
+		entry.err = fmt.Errorf("failed to get JWK Set from \"jku\" header: %w", err)
+		entry.failedAt = time.Now()
+	} else {
+		entry.jwks = jwks
+		// The entry may have been evicted or the middleware closed while fetching.
+		if j.sets[jwksURL] != entry {
+			jwks.EndBackground()
+			j.index.remove(jwksURL)
+		}
+	}
+	j.mux.Unlock()
+	close(entry.ready)
+	return entry.jwks, entry.err
+}
+
+// evict drops the least recently used JWK Set if the cache is full. It must be called with the lock held.
+func (j *jkuKeyfunc) evict() {
+	if len(j.sets) < maxJKUSets {
+		return
+	}
+	var oldestURL string
+	var oldest *jkuEntry
+	for u, entry := range j.sets {
+		if oldest == nil || entry.lastUsed.Before(oldest.lastUsed) {
+			oldestURL, oldest = u, entry
+		}
+	}
+	delete(j.sets, oldestURL)
+	j.index.remove(oldestURL)
+	if oldest.jwks != nil {
+		oldest.jwks.EndBackground()
+	}
+}
+
+// observeRefresh wraps the given options so that onRefresh is called after every refresh of the JWK Set.
+func observeRefresh(jwksURL string, opts keyfunc.Options, onRefresh func(url string, err error, duration time.Duration), unmarshal utils.JSONUnmarshal) keyfunc.Options {
+	var mux sync.Mutex
+	var start time.Time
+	elapsed := func() time.Duration {
+		mux.Lock()
+		defer mux.Unlock()
+		return time.Since(start)
+	}
+
+	requestFactory := opts.RequestFactory
+	opts.RequestFactory = func(ctx context.Context, url string) (*http.Request, error) {
+		mux.Lock()
+		start = time.Now()
+		mux.Unlock()
+		return requestFactory(ctx, url)
+	}
+	responseExtractor := opts.ResponseExtractor
+	if responseExtractor == nil {
+		responseExtractor = keyfunc.ResponseExtractorStatusOK
+	}
+	opts.ResponseExtractor = func(ctx context.Context, resp *http.Response) (json.RawMessage, error) {
+		raw, err := responseExtractor(ctx, resp)
+		if err == nil {
+			// Reject what keyfunc would fail to parse, so that only actual successes are reported here.
+			var keySet struct {
+				Keys []json.RawMessage `json:"keys"`
+			}
+			if err = unmarshal(raw, &keySet); err != nil {
+				return nil, err
+			}
+			onRefresh(jwksURL, nil, elapsed())
+		}
+		return raw, err
+	}
+	errorHandler := opts.RefreshErrorHandler
+	opts.RefreshErrorHandler = func(err error) {
+		onRefresh(jwksURL, err, elapsed())
+		if errorHandler != nil {
+			errorHandler(err)
+		}
+	}
+	return opts
+}
+
+// KeyInfo describes a key known to the middleware.
+type KeyInfo struct {
+	// KID is the key ID. It is empty for SigningKey.
+	KID string
+	// Algorithm is the expected JWT algorithm of the key, if known.
+	Algorithm string
+	// Source is the URL of the JWK Set the key was fetched from. It is empty for SigningKey and SigningKeys.
+	Source string
+}
+
+// jwksKeyInfos returns the keys of the given JWK Set. The algorithms are read from the raw JWK Set,
+// falling back to the algorithms of the given keys.
+func jwksKeyInfos(source string, jwks *keyfunc.JWKS, givenAlgs map[string]string, unmarshal utils.JSONUnmarshal) []KeyInfo {
+	algs := make(map[string]string, len(givenAlgs))
+	for kid, alg := range givenAlgs {
+		algs[kid] = alg
+	}
+	for kid, alg := range keySetAlgs(jwks.RawJWKS(), unmarshal) {
+		algs[kid] = alg
+	}
+
+	kids := jwks.KIDs()
+	infos := make([]KeyInfo, 0, len(kids))
+	for _, kid := range kids {
+		infos = append(infos, KeyInfo{KID: kid, Algorithm: algs[kid], Source: source})
+	}
+	return infos
+}
+
+// keySetAlgs returns the algorithms of the keys in the given raw JWK Set which declare one, keyed by "kid".
+func keySetAlgs(raw []byte, unmarshal utils.JSONUnmarshal) map[string]string {
+	var keySet struct {
+		Keys []struct {
+			Algorithm string `json:"alg"`
+			ID        string `json:"kid"`
+		} `json:"keys"`
+	}
+	algs := make(map[string]string)
+	if err := unmarshal(raw, &keySet); err != nil {
+		return algs
+	}
+	for _, key := range keySet.Keys {
+		if key.Algorithm != "" {
+			algs[key.ID] = key.Algorithm
+		}
+	}
+	return algs
+}
+
+// keySetIndex indexes the keys of JWK Sets which keyfunc cannot tell apart or parse: keys whose "kid" is
+// published more than once with different algorithms, as keyfunc indexes keys by "kid" only so that all but
+// one of them would be lost, and secp256k1 keys, which keyfunc skips. It is updated whenever a JWK Set is
+// fetched, see indexKeySet, so that requests only look up the prepared index.
+type keySetIndex struct {
+	unmarshal utils.JSONUnmarshal
+
+	mux     sync.Mutex
+	sources map[string]map[string]map[string]interface{}
+	// keys holds a keySetKeys snapshot. It is replaced on every update.
+	keys atomic.Value
+}
+
+// keySetKeys holds indexed keys keyed by "kid" and then by "alg".
+type keySetKeys struct {
+	// merged holds the keys of all sources.
+	merged map[string]map[string]interface{}
+	// bySource holds the keys of each source separately.
+	bySource map[string]map[string]map[string]interface{}
+}
+
+func newKeySetIndex(unmarshal utils.JSONUnmarshal) *keySetIndex {
+	x := &keySetIndex{unmarshal: unmarshal, sources: make(map[string]map[string]map[string]interface{})}
+	x.keys.Store(keySetKeys{})
+	return x
+}
+
+// update indexes the given raw JWK Set of the given source, replacing the keys previously indexed for it.
+func (x *keySetIndex) update(source string, raw []byte) {
+	keys := parseKIDAlgKeys(raw, x.unmarshal)
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	if len(keys) == 0 {
+		if _, ok := x.sources[source]; !ok {
+			return
+		}
+		delete(x.sources, source)
+	} else {
+		x.sources[source] = keys
+	}
+	x.rebuild()
+}
+
+// remove drops the keys of the given source, e.g. of an evicted JWK Set.
+func (x *keySetIndex) remove(source string) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	if _, ok := x.sources[source]; ok {
+		delete(x.sources, source)
+		x.rebuild()
+	}
+}
+
+// rebuild merges the keys of all sources. It must be called with the lock held.
+func (x *keySetIndex) rebuild() {
+	merged := make(map[string]map[string]interface{})
+	bySource := make(map[string]map[string]map[string]interface{}, len(x.sources))
+	for source, keys := range x.sources {
+		bySource[source] = keys
+		for kid, algs := range keys {
+			if merged[kid] == nil {
+				merged[kid] = make(map[string]interface{}, len(algs))
+			}
+			for alg, key := range algs {
+				merged[kid][alg] = key
+			}
+		}
+	}
+	x.keys.Store(keySetKeys{merged: merged, bySource: bySource})
+}
+
+// lookup returns the indexed keys of all sources with the given "kid", keyed by "alg".
+func (x *keySetIndex) lookup(kid string) (map[string]interface{}, bool) {
+	algs, ok := x.keys.Load().(keySetKeys).merged[kid]
+	return algs, ok
+}
+
+// lookupSource returns the indexed keys of the given source with the given "kid", keyed by "alg".
+func (x *keySetIndex) lookupSource(source, kid string) (map[string]interface{}, bool) {
+	algs, ok := x.keys.Load().(keySetKeys).bySource[source][kid]
+	return algs, ok
+}
+
+// indexKeySet wraps the given options so that every fetched JWK Set is indexed under its URL.
+func indexKeySet(jwksURL string, opts keyfunc.Options, index *keySetIndex) keyfunc.Options {
+	responseExtractor := opts.ResponseExtractor
+	if responseExtractor == nil {
+		responseExtractor = keyfunc.ResponseExtractorStatusOK
+	}
+	opts.ResponseExtractor = func(ctx context.Context, resp *http.Response) (json.RawMessage, error) {
+		raw, err := responseExtractor(ctx, resp)
+		if err == nil {
+			index.update(jwksURL, raw)
+		}
+		return raw, err
+	}
+	return opts
+}
+
+// kidAlgKeyfunc returns a jwt.Keyfunc selecting the keys of the given indexes with the token's "kid" by the
+// token's "alg", falling back to next for tokens whose "kid" is not indexed.
+func kidAlgKeyfunc(indexes []*keySetIndex, next jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return next(token)
+		}
+		for _, index := range indexes {
+			if algs, ok := index.lookup(kid); ok {
+				return kidAlgKey(token, kid, algs)
+			}
+		}
+		return next(token)
+	}
+}
+
+// kidAlgKey returns the key for the token's "alg" among the given indexed keys with the token's "kid".
+func kidAlgKey(token *jwt.Token, kid string, algs map[string]interface{}) (interface{}, error) {
+	alg, _ := token.Header["alg"].(string)
+	key, ok := algs[alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: no key with ID %q for algorithm %q", ErrJWTAlg, kid, alg)
+	}
+	return key, nil
+}
+
+// parseKIDAlgKeys parses the keys of the given raw JWK Set whose "kid" occurs more than once, and the
+// secp256k1 keys, which are indexed under ES256K unless they declare another "alg". Each other key is parsed
+// into a JWK Set of its own, so that it is not overwritten by its namesakes. Keys for encryption are skipped.
+func parseKIDAlgKeys(raw []byte, unmarshal utils.JSONUnmarshal) map[string]map[string]interface{} {
+	var keySet struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := unmarshal(raw, &keySet); err != nil {
+		return nil
+	}
+	byKID := make(map[string][]json.RawMessage)
+	headers := make(map[string][]secp256k1JWK)
+	for _, key := range keySet.Keys {
+		var header secp256k1JWK
+		if err := unmarshal(key, &header); err != nil || header.ID == "" || (header.Use != "" && header.Use != "sig") {
+			continue
+		}
+		if header.Curve == Secp256k1 && header.Algorithm == "" {
+			header.Algorithm = ES256K
+		}
+		if header.Algorithm == "" {
+			continue
+		}
+		byKID[header.ID] = append(byKID[header.ID], key)
+		headers[header.ID] = append(headers[header.ID], header)
+	}
+
+	indexed := make(map[string]map[string]interface{})
+	for kid, keys := range byKID {
+		if len(keys) < 2 && headers[kid][0].Curve != Secp256k1 {
+			continue
+		}
+		indexed[kid] = make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			header := headers[kid][i]
+			if header.Curve == Secp256k1 {
+				if public, err := parseSecp256k1JWK(header); err == nil {
+					indexed[kid][header.Algorithm] = public
+				}
+				continue
+			}
+			single, err := json.Marshal(map[string][]json.RawMessage{"keys": {key}})
+			if err != nil {
+				continue
+			}
+			jwks, err := keyfunc.NewJSON(single)
+			if err != nil {
+				continue
+			}
+			for _, public := range jwks.ReadOnlyKeys() {
+				indexed[kid][header.Algorithm] = public
+			}
+		}
+	}
+	return indexed
+}
@@ -0,0 +1,117 @@
+package jwtware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// streamingJWKSResponseExtractor is like jwksResponseExtractor, but decodes the "keys" array of the JWK Set
+// one key at a time instead of reading the whole body first, see streamKeySet.
+func streamingJWKSResponseExtractor(_ context.Context, resp *http.Response) (json.RawMessage, error) {
+	if err := prepareJWKSResponse(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	keySet, err := streamKeySet(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWK Set from %s: %w", resp.Request.URL, err)
+	}
+	return keySet, nil
+}
+
+// streamKeySet reads a JWK Set from r and returns it as {"keys":[...]} holding the compacted keys which may
+// be used for signatures. Other members of the JWK Set and keys with "use":"enc", which keyfunc ignores
+// anyway, are skipped without being buffered.
+func streamKeySet(r io.Reader) (json.RawMessage, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var keys bytes.Buffer
+	for dec.More() {
+		name, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if name != "keys" {
+			if err = skipValue(dec); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// As with json.Unmarshal, the last "keys" member wins.
+		keys.Reset()
+		if err = streamKeys(dec, &keys); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(`{"keys":[` + keys.String() + `]}`), nil
+}
+
+// streamKeys appends the signature keys of the "keys" array to buf, separated by commas.
+func streamKeys(dec *json.Decoder, buf *bytes.Buffer) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		var key json.RawMessage
+		if err := dec.Decode(&key); err != nil {
+			return err
+		}
+		var use struct {
+			Use string `json:"use"`
+		}
+		if err := json.Unmarshal(key, &use); err != nil {
+			return err
+		}
+		if use.Use == "enc" {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(',')
+		}
+		if err := json.Compact(buf, key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads the next token and fails unless it is the given delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %q, got %v", delim, token)
+	}
+	return nil
+}
+
+// skipValue reads the next value token by token, without buffering it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
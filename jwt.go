@@ -1,20 +1,26 @@
 package jwtware
 
 import (
-	"errors"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
 )
 
 var (
 	// ErrJWTMissingOrMalformed is returned when the JWT is missing or malformed.
-	ErrJWTMissingOrMalformed = errors.New("missing or malformed JWT")
+	ErrJWTMissingOrMalformed = newStatusError(fiber.StatusUnauthorized, "missing or malformed JWT")
+
+	// ErrJWTTooLarge is returned when the JWT is longer than the configured maximum length.
+	ErrJWTTooLarge = newStatusError(fiber.StatusUnauthorized, "the JWT exceeds the maximum length")
 )
 
 type jwtExtractor func(c *fiber.Ctx) (string, error)
 
-// jwtFromHeader returns a function that extracts token from the request header.
+// jwtFromHeader returns a function that extracts token from the request header, which may be any header
+// such as Proxy-Authorization.
 func jwtFromHeader(header string, authScheme string) func(c *fiber.Ctx) (string, error) {
 	return func(c *fiber.Ctx) (string, error) {
 		auth := c.Get(header)
@@ -33,8 +39,27 @@ func jwtFromQuery(param string) func(c *fiber.Ctx) (string, error) {
 		if token == "" {
 			return "", ErrJWTMissingOrMalformed
 		}
-		return token, nil
+		return decodeQueryToken(token), nil
+	}
+}
+
+// decodeQueryToken URL-decodes a token which intermediaries encoded twice, e.g. with "%2E" for its dots, so
+// that it still has the wrong number of segments after the query string was decoded once. Other tokens are
+// returned unchanged.
+func decodeQueryToken(token string) string {
+	if isCompactToken(token) || !strings.Contains(token, "%") {
+		return token
+	}
+	if decoded, err := url.QueryUnescape(token); err == nil && isCompactToken(decoded) {
+		return decoded
 	}
+	return token
+}
+
+// isCompactToken reports whether the token has the segments of a compact JWS or JWE.
+func isCompactToken(token string) bool {
+	dots := strings.Count(token, ".")
+	return dots == 2 || dots == 4
 }
 
 // jwtFromParam returns a function that extracts token from the url param string.
@@ -58,3 +83,38 @@ func jwtFromCookie(name string) func(c *fiber.Ctx) (string, error) {
 		return token, nil
 	}
 }
+
+// jwtFromSplitCookies returns a function that extracts a token split across the cookies
+// "<prefix>.0", "<prefix>.1", ... by concatenating them in order.
+func jwtFromSplitCookies(prefix string) func(c *fiber.Ctx) (string, error) {
+	return func(c *fiber.Ctx) (string, error) {
+		var token strings.Builder
+		for i := 0; ; i++ {
+			part := c.Cookies(prefix + "." + strconv.Itoa(i))
+			if part == "" {
+				break
+			}
+			token.WriteString(part)
+		}
+		// Only compact JWS (three segments) and JWE (five segments) tokens are accepted.
+		if dots := strings.Count(token.String(), "."); dots != 2 && dots != 4 {
+			return "", ErrJWTMissingOrMalformed
+		}
+		return token.String(), nil
+	}
+}
+
+// jwtFromSession returns a function that extracts token from the session.
+func jwtFromSession(store *session.Store, key string) func(c *fiber.Ctx) (string, error) {
+	return func(c *fiber.Ctx) (string, error) {
+		sess, err := store.Get(c)
+		if err != nil {
+			return "", err
+		}
+		token, ok := sess.Get(key).(string)
+		if !ok || token == "" {
+			return "", ErrJWTMissingOrMalformed
+		}
+		return token, nil
+	}
+}
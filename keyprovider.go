@@ -0,0 +1,160 @@
+package jwtware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultKeyProviderCacheTTL         = 5 * time.Minute
+	defaultKeyProviderNegativeCacheTTL = 10 * time.Second
+	defaultKeyProviderCacheSize        = 100
+)
+
+// KeyProvider supplies the key for the given "kid", e.g. from a key-value store such as etcd or Consul.
+// The context is the one of the request, see fiber.Ctx.UserContext.
+type KeyProvider func(ctx context.Context, kid string) (interface{}, error)
+
+// providerKeyfunc looks up keys in the given keys first and asks the KeyProvider for unknown "kid"s,
+// caching the keys it returns for ttl and its failures for negativeTTL, at most size of them. Concurrent
+// lookups of the same "kid" share one call of the KeyProvider.
+type providerKeyfunc struct {
+	provider    KeyProvider
+	given       *keyfunc.JWKS
+	ttl         time.Duration
+	negativeTTL time.Duration
+	size        int
+	now         func() time.Time
+
+	mux   sync.Mutex
+	cache map[string]providerKey
+	calls map[string]*providerCall
+}
+
+// providerKey is a key returned by the KeyProvider, or the error it failed with.
+type providerKey struct {
+	key     interface{}
+	err     error
+	expires time.Time
+}
+
+// providerCall is a call of the KeyProvider in progress. key and err are set before done is closed.
+type providerCall struct {
+	done chan struct{}
+	key  interface{}
+	err  error
+}
+
+func newProviderKeyfunc(provider KeyProvider, givenKeys map[string]keyfunc.GivenKey, ttl, negativeTTL time.Duration, size int, now func() time.Time) *providerKeyfunc {
+	return &providerKeyfunc{
+		provider:    provider,
+		given:       keyfunc.NewGiven(givenKeys),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		size:        size,
+		now:         now,
+		cache:       make(map[string]providerKey),
+		calls:       make(map[string]*providerCall),
+	}
+}
+
+// keyfunc returns a jwt.Keyfunc using the given keys and the KeyProvider, which is passed the given context.
+func (p *providerKeyfunc) keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		key, err := p.given.Keyfunc(token)
+		if err == nil || !errors.Is(err, keyfunc.ErrKIDNotFound) {
+			return key, err
+		}
+		kid := token.Header["kid"].(string) // Checked by the given keys.
+		if cached, ok := p.load(kid); ok {
+			return cached.key, cached.err
+		}
+		return p.fetch(ctx, kid)
+	}
+}
+
+// fetch asks the KeyProvider for the key, or waits for the call already asking it.
+func (p *providerKeyfunc) fetch(ctx context.Context, kid string) (interface{}, error) {
+	p.mux.Lock()
+	call, ok := p.calls[kid]
+	if ok {
+		p.mux.Unlock()
+		select {
+		case <-call.done:
+			return call.key, call.err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to get key %q from KeyProvider: %w", kid, ctx.Err())
+		}
+	}
+	call = &providerCall{done: make(chan struct{})}
+	p.calls[kid] = call
+	p.mux.Unlock()
+
+	defer func() {
+		p.mux.Lock()
+		delete(p.calls, kid)
+		// A failure caused by the request going away says nothing about the key.
+		if call.err == nil || ctx.Err() == nil {
+			p.store(kid, call.key, call.err)
+		}
+		p.mux.Unlock()
+		close(call.done)
+	}()
+	call.key, call.err = p.provider(ctx, kid)
+	if call.err != nil {
+		call.key, call.err = nil, fmt.Errorf("failed to get key %q from KeyProvider: %w", kid, call.err)
+	}
+	return call.key, call.err
+}
+
+func (p *providerKeyfunc) load(kid string) (providerKey, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	cached, ok := p.cache[kid]
+	if !ok || !p.now().Before(cached.expires) {
+		return providerKey{}, false
+	}
+	return cached, true
+}
+
+// store caches the key or the error, dropping expired entries and then the one expiring first if the cache is
+// full. It must be called with the lock held.
+func (p *providerKeyfunc) store(kid string, key interface{}, err error) {
+	now := p.now()
+	if _, ok := p.cache[kid]; !ok && len(p.cache) >= p.size {
+		var first string
+		var firstExpires time.Time
+		for cachedKID, cached := range p.cache {
+			if !now.Before(cached.expires) {
+				delete(p.cache, cachedKID)
+			} else if firstExpires.IsZero() || cached.expires.Before(firstExpires) {
+				first, firstExpires = cachedKID, cached.expires
+			}
+		}
+		if len(p.cache) >= p.size {
+			delete(p.cache, first)
+		}
+	}
+	ttl := p.ttl
+	if err != nil {
+		ttl = p.negativeTTL
+	}
+	p.cache[kid] = providerKey{key: key, err: err, expires: now.Add(ttl)}
+}
+
+// invalidate drops the cached key or failure with the given "kid", or all of them if kid is empty.
+func (p *providerKeyfunc) invalidate(kid string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if kid == "" {
+		p.cache = make(map[string]providerKey)
+		return
+	}
+	delete(p.cache, kid)
+}
@@ -6,8 +6,20 @@
 package jwtware
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
+	"github.com/MicahParks/keyfunc/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -16,11 +28,166 @@ var (
 	defaultTokenLookup = "header:" + fiber.HeaderAuthorization
 )
 
+const (
+	defaultContextKey        = "user"
+	rawTokenContextKeySuffix = "_raw"
+	defaultMaxTokenLength    = 8 * 1024
+
+	// UnverifiedContextKey is the context key set to true for tokens accepted without verification by
+	// FailOpenOnKeyUnavailable.
+	UnverifiedContextKey = "jwt_unverified"
+
+	// ExpiresInContextKey is the context key the remaining lifetime of a token with an "exp" claim is
+	// stored under, as time.Duration.
+	ExpiresInContextKey = "jwt_expires_in"
+	// expiresInHeader is the response header set to the remaining lifetime in seconds if EmitExpiresInHeader is set.
+	expiresInHeader = "X-Token-Expires-In"
+)
+
+// Middleware is a JWT middleware instance. Unlike New, it gives access to the state of the middleware.
+type Middleware struct {
+	cfg           Config
+	customKeyFunc bool
+	handler       fiber.Handler
+	verifier      *verifier
+}
+
 // New ...
 func New(config ...Config) fiber.Handler {
-	cfg := makeCfg(config)
+	return NewMiddleware(config...).Handler()
+}
+
+// NewWithContext is like New, but stops the background refreshes of JWK Sets once the given context is done,
+// like Middleware.Close, e.g. on application shutdown or at the end of a test. The handler keeps working
+// afterwards, but JWK Sets are no longer refreshed.
+func NewWithContext(ctx context.Context, config Config) fiber.Handler {
+	m := NewMiddleware(config)
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			m.Close()
+		}()
+	}
+	return m.Handler()
+}
+
+// NewMiddleware creates a new JWT middleware instance. Use Handler to mount it and Close to stop
+// the background refreshes of JWK Sets.
+func NewMiddleware(config ...Config) *Middleware {
+	m := &Middleware{
+		cfg:           makeCfg(config),
+		customKeyFunc: len(config) > 0 && config[0].KeyFunc != nil,
+	}
+	m.handler = newHandler(m.cfg)
+	m.verifier = newVerifier(&m.cfg)
+	return m
+}
+
+// Close stops the background refreshes of all JWK Sets fetched by the middleware. Without calling it,
+// their goroutines leak for the lifetime of the process, which matters in tests creating many instances.
+// The handler keeps working after Close, but JWK Sets are no longer refreshed.
+func (m *Middleware) Close() {
+	closeConfig(&m.cfg)
+}
+
+// closeConfig stops the background refreshes of the JWK Sets of the given and the additional configurations.
+func closeConfig(cfg *Config) {
+	if cfg.releaseJWKS != nil {
+		cfg.releaseJWKS()
+	}
+	if cfg.jku != nil {
+		cfg.jku.close()
+	}
+	for i := range cfg.additional {
+		closeConfig(&cfg.additional[i])
+	}
+}
+
+// InvalidateKey drops the key with the given "kid" from the cache of KeyProvider, or all cached keys if kid is
+// empty, e.g. when a watch on the key-value store reports a rotated key. The next token with that "kid" asks
+// KeyProvider again. Tokens already in the verification cache, see VerificationCacheTTL, stay accepted until
+// they expire from it.
+func (m *Middleware) InvalidateKey(kid string) {
+	if m.cfg.provider != nil {
+		m.cfg.provider.invalidate(kid)
+	}
+}
+
+// Handler returns the fiber.Handler of the middleware.
+func (m *Middleware) Handler() fiber.Handler {
+	return m.handler
+}
+
+// ParseAndValidate verifies the given token with the key resolution and claim validations of the middleware,
+// e.g. for tokens received outside of HTTP requests. Request specific checks such as DPoP are not performed.
+func (m *Middleware) ParseAndValidate(tokenString string) (*jwt.Token, error) {
+	return m.verifier.parseAndValidate(tokenString)
+}
+
+// ParseAndValidate verifies the given token like Middleware.ParseAndValidate. It builds the complete
+// configuration on every call, which fetches JWK Sets; use NewMiddleware to verify many tokens.
+func (cfg Config) ParseAndValidate(tokenString string) (*jwt.Token, error) {
+	m := NewMiddleware(cfg)
+	defer m.Close()
+	return m.ParseAndValidate(tokenString)
+}
+
+// KnownKIDs returns the keys the middleware currently trusts, sorted by source and key ID.
+// It is meant for debugging, e.g. after a key rotation. Keys supplied by a custom KeyFunc are not included.
+func (m *Middleware) KnownKIDs() []KeyInfo {
+	givenAlgs := make(map[string]string, len(m.cfg.SigningKeys))
+	givenKIDs := make(map[string]struct{})
+	if len(m.cfg.JWKSetJSON) > 0 {
+		if keys, err := keyfunc.NewGivenKeysFromJSON(m.cfg.JWKSetJSON); err == nil {
+			for kid := range keys {
+				givenKIDs[kid] = struct{}{}
+			}
+		}
+		for kid, alg := range keySetAlgs(m.cfg.JWKSetJSON, m.cfg.JSONUnmarshal) {
+			givenAlgs[kid] = alg
+		}
+	}
+	for kid, key := range m.cfg.SigningKeys {
+		givenKIDs[kid] = struct{}{}
+		givenAlgs[kid] = key.JWTAlg
+	}
+
+	var infos []KeyInfo
+	switch {
+	case m.customKeyFunc:
+	case m.cfg.jwks != nil:
+		for url, jwks := range m.cfg.jwks.JWKSets() {
+			infos = append(infos, jwksKeyInfos(url, jwks, givenAlgs, m.cfg.JSONUnmarshal)...)
+		}
+	case len(givenKIDs) > 0:
+		for kid := range givenKIDs {
+			infos = append(infos, KeyInfo{KID: kid, Algorithm: givenAlgs[kid]})
+		}
+	case m.cfg.SigningKey.Key != nil:
+		infos = append(infos, KeyInfo{Algorithm: m.cfg.SigningKey.JWTAlg})
+	}
+	if m.cfg.jku != nil {
+		for url, jwks := range m.cfg.jku.JWKSets() {
+			infos = append(infos, jwksKeyInfos(url, jwks, nil, m.cfg.JSONUnmarshal)...)
+		}
+	}
 
-	extractors := cfg.getExtractors()
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Source != infos[j].Source {
+			return infos[i].Source < infos[j].Source
+		}
+		return infos[i].KID < infos[j].KID
+	})
+	return infos
+}
+
+// newHandler returns the middleware handler for the given, complemented configuration.
+func newHandler(cfg Config) fiber.Handler {
+	main := newVerifier(&cfg)
+	additional := make([]*verifier, len(cfg.additional))
+	for i := range cfg.additional {
+		additional[i] = newVerifier(&cfg.additional[i])
+	}
 
 	// Return middleware handler
 	return func(c *fiber.Ctx) error {
@@ -28,32 +195,420 @@ func New(config ...Config) fiber.Handler {
 		if cfg.Filter != nil && cfg.Filter(c) {
 			return c.Next()
 		}
-		var auth string
-		var err error
-
-		for _, extractor := range extractors {
-			auth, err = extractor(c)
-			if auth != "" && err == nil {
-				break
+		if cfg.FailureLimiter != nil && !cfg.FailureLimiter.Allow(cfg.FailureLimiterKey(c)) {
+			return cfg.ErrorHandler(c, newAuthError(StageExtraction, ErrJWTTooManyFailures, nil, ""))
+		}
+		auth, signed, err := main.extract(c)
+		if cfg.TrustFunc != nil && cfg.TrustFunc(c) {
+			// Best effort: populate the context without verifying the token.
+			if err == nil {
+				if token, _, err := main.parser.ParseUnverified(signed, main.newClaims()); err == nil {
+					cfg.storeToken(c, token, auth)
+				}
 			}
+			return cfg.SuccessHandler(c)
 		}
 		if err != nil {
-			return cfg.ErrorHandler(c, err)
+			if cfg.Optional && auth == "" && errors.Is(err, ErrJWTMissingOrMalformed) {
+				return c.Next()
+			}
+			if auth != "" {
+				cfg.recordFailure(c)
+			}
+			return cfg.ErrorHandler(c, newAuthError(StageExtraction, err, nil, signed))
+		}
+		token, err := main.verify(c.UserContext(), signed)
+		if err != nil && token != nil && cfg.OnExpired != nil {
+			if err = cfg.OnExpired(c, token); err != nil {
+				cfg.recordFailure(c)
+				return cfg.ErrorHandler(c, newAuthError(StageValidation, err, token, signed))
+			}
 		}
-		var token *jwt.Token
+		unverified := false
+		if err != nil && cfg.keyHealth != nil && cfg.keyHealth.keyUnavailable(err) {
+			// The unverified token still goes through the checks and hooks below like a verified one.
+			if parsed, _, perr := main.parser.ParseUnverified(signed, main.newClaims()); perr == nil && cfg.validateToken(parsed) == nil {
+				cfg.Logger.Printf("WARNING: accepting an unverified JWT for %s %s, as its key is unavailable: %s.", c.Method(), c.Path(), err)
+				token, err, unverified = parsed, nil, true
+			}
+		}
+		if err != nil {
+			cfg.recordFailure(c)
+			if cfg.DebugHMACFailures {
+				cfg.logHMACFailure(signed, err)
+			}
+			return cfg.ErrorHandler(c, newAuthError(verifyStage(err), err, nil, signed))
+		}
+		if cfg.dpop != nil {
+			if err = cfg.dpop.verify(c, auth, token); err != nil {
+				cfg.recordFailure(c)
+				return cfg.ErrorHandler(c, newAuthError(StageValidation, err, token, signed))
+			}
+		}
+		if cfg.ValidateCertBinding {
+			if err = validateCertBinding(c, token); err != nil {
+				cfg.recordFailure(c)
+				return cfg.ErrorHandler(c, newAuthError(StageValidation, err, token, signed))
+			}
+		}
+		if cfg.ValidateClientIP {
+			if err = validateClientIP(c, token); err != nil {
+				cfg.recordFailure(c)
+				return cfg.ErrorHandler(c, newAuthError(StageValidation, err, token, signed))
+			}
+		}
+		cfg.storeToken(c, token, auth)
+		if unverified {
+			c.Locals(UnverifiedContextKey, true)
+		}
+		for i, v := range additional {
+			if err = v.verifyRequest(c); err != nil && !cfg.AdditionalTokens[i].Optional {
+				cfg.recordFailure(c)
+				return cfg.ErrorHandler(c, err)
+			}
+		}
+		if cfg.BeforeNext != nil {
+			if err = cfg.BeforeNext(c, token); err != nil {
+				return cfg.ErrorHandler(c, newAuthError(StageValidation, err, token, signed))
+			}
+		}
+		// The "jti" is recorded last, so that a request rejected by another check does not consume the token.
+		if cfg.ReplayCache != nil {
+			if err = cfg.checkReplay(token); err != nil {
+				cfg.recordFailure(c)
+				return cfg.ErrorHandler(c, newAuthError(StageValidation, err, token, signed))
+			}
+		}
+		return cfg.SuccessHandler(c)
+	}
+}
 
-		if _, ok := cfg.Claims.(jwt.MapClaims); ok {
-			token, err = jwt.Parse(auth, cfg.KeyFunc)
-		} else {
-			t := reflect.ValueOf(cfg.Claims).Type().Elem()
-			claims := reflect.New(t).Interface().(jwt.Claims)
-			token, err = jwt.ParseWithClaims(auth, claims, cfg.KeyFunc)
+// verifier extracts and verifies the tokens of a complemented configuration.
+// The parser and the claims type are resolved once, so that no work is repeated per request.
+type verifier struct {
+	cfg        *Config
+	extractors []jwtExtractor
+	parser     *jwt.Parser
+	// claimsParser validates the claims of tokens whose signature was verified without parser, e.g. with
+	// an unencoded payload. It has the options of parser, but accepts the "none" algorithm.
+	claimsParser *jwt.Parser
+	// claimsType is the struct type of the configured claims, nil for jwt.MapClaims.
+	claimsType reflect.Type
+
+	// methods caches whether parser accepts an algorithm, keyed by "alg", see checkMethod.
+	methods sync.Map
+}
+
+func newVerifier(cfg *Config) *verifier {
+	opts := []jwt.ParserOption{jwt.WithLeeway(cfg.Leeway), jwt.WithTimeFunc(cfg.TimeFunc)}
+	if cfg.ExpectedAudience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.ExpectedAudience))
+	}
+	v := &verifier{
+		cfg:        cfg,
+		extractors: cfg.getExtractors(),
+		parser:     jwt.NewParser(append(opts, cfg.ParserOptions...)...),
+	}
+	opts = append(opts, cfg.ParserOptions...)
+	v.claimsParser = jwt.NewParser(append(opts, jwt.WithValidMethods([]string{jwt.SigningMethodNone.Alg()}))...)
+	if _, ok := cfg.Claims.(jwt.MapClaims); !ok {
+		v.claimsType = reflect.TypeOf(cfg.Claims).Elem()
+	}
+	return v
+}
+
+// errMethodProbe is returned by the key function of the probe of checkMethod.
+var errMethodProbe = errors.New("signing method accepted")
+
+// checkMethod returns the error of parser for tokens signed with the given algorithm if it rejects the algorithm,
+// e.g. because of jwt.WithValidMethods in ParserOptions. It is meant for signatures verified without parser.
+func (v *verifier) checkMethod(alg string) error {
+	if err, ok := v.methods.Load(alg); ok {
+		if err == nil {
+			return nil
 		}
-		if err == nil && token.Valid {
-			// Store user information from token into context.
-			c.Locals(cfg.ContextKey, token)
-			return cfg.SuccessHandler(c)
+		return err.(error)
+	}
+	// The parser checks the algorithm before looking up the key, so the probe reaching the key function
+	// means that the algorithm is accepted.
+	header, err := json.Marshal(map[string]string{"alg": alg})
+	if err != nil {
+		return err
+	}
+	probe := base64.RawURLEncoding.EncodeToString(header) + ".e30."
+	_, err = v.parser.Parse(probe, func(*jwt.Token) (interface{}, error) {
+		return nil, errMethodProbe
+	})
+	if errors.Is(err, errMethodProbe) {
+		err = nil
+	}
+	v.methods.Store(alg, err)
+	return err
+}
+
+// newClaims returns a new, empty instance of the configured claims type.
+func (v *verifier) newClaims() jwt.Claims {
+	if v.claimsType == nil {
+		return jwt.MapClaims{}
+	}
+	return reflect.New(v.claimsType).Interface().(jwt.Claims)
+}
+
+// extract returns the token of the request as found by the extractors, and the signed JWT within it,
+// which differs from the former for encrypted JWTs (JWE).
+func (v *verifier) extract(c *fiber.Ctx) (auth, signed string, err error) {
+	// Try the extractors in order, a missing token just falls through to the next one.
+	for _, extractor := range v.extractors {
+		auth, err = extractor(c)
+		if auth != "" && err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return auth, "", err
+	}
+	if v.cfg.TokenTransform != nil {
+		if auth, err = v.cfg.TokenTransform(auth); err != nil {
+			return "", "", err
+		}
+	}
+	signed, err = v.signedJWT(auth)
+	return auth, signed, err
+}
+
+// signedJWT checks the length of the given token and returns the signed JWT within it, decrypting it if needed.
+func (v *verifier) signedJWT(token string) (string, error) {
+	if v.cfg.MaxTokenLength > 0 && len(token) > v.cfg.MaxTokenLength {
+		return "", ErrJWTTooLarge
+	}
+	if isJWE(token) {
+		return decryptJWE(token, v.cfg.DecryptionKey, v.cfg.DecryptionAlgorithms, v.cfg.DecryptionEncryptions)
+	}
+	return token, nil
+}
+
+// verify parses the signed JWT, verifies its signature and validates its claims.
+// If expiry is the only failure, the token is returned along with the error.
+func (v *verifier) verify(ctx context.Context, signed string) (*jwt.Token, error) {
+	if v.cfg.cache != nil {
+		if token, ok := v.cfg.cache.get(signed); ok {
+			return token, nil
+		}
+	}
+	token, err := v.verifyDepth(ctx, signed, 0)
+	if err == nil && v.cfg.cache != nil {
+		v.cfg.cache.put(signed, token)
+	}
+	return token, err
+}
+
+// verifyDepth verifies the signed JWT found at the given nesting depth.
+func (v *verifier) verifyDepth(ctx context.Context, signed string, depth int) (*jwt.Token, error) {
+	if v.cfg.StrictJSON {
+		if err := checkDuplicateKeys(signed, v.cfg.JSONUnmarshal); err != nil {
+			return nil, err
+		}
+	}
+	if header, ok := nestedHeader(signed, v.cfg.JSONUnmarshal); ok {
+		return v.verifyNested(ctx, signed, header, depth)
+	}
+	var token *jwt.Token
+	var err error
+	if header, ok := v.unencodedHeader(signed); ok {
+		token, err = v.verifyUnencoded(ctx, signed, header)
+	} else {
+		token, err = v.parser.ParseWithClaims(signed, v.newClaims(), v.keyFunc(ctx))
+	}
+	if err != nil && token != nil && (errors.Is(err, jwt.ErrTokenInvalidAudience) || errors.Is(err, jwt.ErrTokenRequiredClaimMissing)) {
+		if aerr := validateAudienceType(token.Claims); aerr != nil {
+			err = aerr
+		}
+	}
+	if err != nil && !(token != nil && onlyExpired(err)) {
+		if errors.Is(err, jwt.ErrTokenNotValidYet) {
+			err = notYetValidError{err: err}
+		}
+		return nil, err
+	}
+	if verr := v.cfg.validateToken(token); verr != nil {
+		return nil, verr
+	}
+	return token, err
+}
+
+// keyFunc returns the KeyFunc of the configuration, which stops waiting for the key once the given context is done,
+// e.g. while a JWK Set is refreshed for an unknown "kid". The lookup itself is not interrupted and finishes in the
+// background, bounded by JWKSetRefreshTimeout.
+func (v *verifier) keyFunc(ctx context.Context) jwt.Keyfunc {
+	keyFunc := v.cfg.KeyFunc
+	if v.cfg.keyFuncContext != nil {
+		keyFunc = v.cfg.keyFuncContext(ctx)
+	}
+	if ctx.Done() == nil {
+		return keyFunc
+	}
+	return func(token *jwt.Token) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		type result struct {
+			key interface{}
+			err error
+		}
+		done := make(chan result, 1)
+		go func() {
+			key, err := keyFunc(token)
+			done <- result{key: key, err: err}
+		}()
+		select {
+		case r := <-done:
+			return r.key, r.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// parseAndValidate verifies the given token, which may be encrypted.
+func (v *verifier) parseAndValidate(tokenString string) (*jwt.Token, error) {
+	signed, err := v.signedJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	token, err := v.verify(context.Background(), signed)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// verifyRequest extracts and verifies the additional token of the request and stores it into context.
+// Failures are returned as an AuthError naming the token.
+func (v *verifier) verifyRequest(c *fiber.Ctx) error {
+	auth, signed, err := v.extract(c)
+	if err != nil {
+		return newAuthError(StageExtraction, fmt.Errorf("additional token %q: %w", v.cfg.ContextKey, err), nil, signed)
+	}
+	token, err := v.verify(c.UserContext(), signed)
+	if err != nil {
+		return newAuthError(verifyStage(err), fmt.Errorf("additional token %q: %w", v.cfg.ContextKey, err), nil, signed)
+	}
+	v.cfg.storeToken(c, token, auth)
+	return nil
+}
+
+// recordFailure reports a failed verification to the FailureLimiter, if any.
+func (cfg *Config) recordFailure(c *fiber.Ctx) {
+	if cfg.FailureLimiter != nil {
+		cfg.FailureLimiter.Failure(cfg.FailureLimiterKey(c))
+	}
+}
+
+// storeToken stores user information from token into context.
+func (cfg *Config) storeToken(c *fiber.Ctx, token *jwt.Token, raw string) {
+	contextKey := cfg.ContextKey
+	if cfg.ContextKeyFunc != nil {
+		contextKey = cfg.ContextKeyFunc(token)
+	}
+	c.Locals(contextKey, token)
+	c.Locals(cfg.RawTokenContextKey, raw)
+	if exp, err := token.Claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresIn := exp.Sub(cfg.TimeFunc())
+		c.Locals(ExpiresInContextKey, expiresIn)
+		if cfg.EmitExpiresInHeader {
+			c.Set(expiresInHeader, strconv.FormatInt(int64(expiresIn/time.Second), 10))
+		}
+	}
+	if cfg.PropagateSubjectHeader != "" {
+		propagateSubject(c, cfg.PropagateSubjectHeader, token)
+	}
+	if len(cfg.ClaimsToLocals) > 0 {
+		claims := claimsMap(token.Claims, cfg.JSONUnmarshal)
+		for claim, key := range cfg.ClaimsToLocals {
+			if value, ok := namespacedValue(claims, cfg.ClaimsNamespace, claim); ok {
+				c.Locals(key, value)
+			}
+		}
+	}
+}
+
+// propagateSubject sets the header to the sanitized subject of the token on the request and the response,
+// and removes a request header of that name if the token has no subject.
+func propagateSubject(c *fiber.Ctx, header string, token *jwt.Token) {
+	subject, _ := token.Claims.GetSubject()
+	subject = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, subject)
+	if subject == "" {
+		c.Request().Header.Del(header)
+		return
+	}
+	c.Request().Header.Set(header, subject)
+	c.Set(header, subject)
+}
+
+// RawTokenFromContext returns the raw, compact token string stored by the middleware.
+// The context key may be given, otherwise the default "user_raw" is used.
+func RawTokenFromContext(c *fiber.Ctx, contextKey ...string) string {
+	key := defaultContextKey + rawTokenContextKeySuffix
+	if len(contextKey) > 0 {
+		key = contextKey[0]
+	}
+	raw, _ := c.Locals(key).(string)
+	return raw
+}
+
+// AlgFromContext returns the algorithm the token stored by the middleware was signed with, e.g. to require
+// asymmetric signatures on some routes, or "" if there is no token. The context key may be given, otherwise
+// the default "user" is used.
+func AlgFromContext(c *fiber.Ctx, contextKey ...string) string {
+	key := defaultContextKey
+	if len(contextKey) > 0 {
+		key = contextKey[0]
+	}
+	token, ok := c.Locals(key).(*jwt.Token)
+	if !ok || token.Method == nil {
+		return ""
+	}
+	return token.Method.Alg()
+}
+
+// ClaimsFromContext returns the claims of the token stored by the middleware as T, i.e. jwt.MapClaims or the
+// type given as Claims such as *MyClaims, e.g. for the resolvers of a GraphQL endpoint using Optional. It
+// returns false if the request has no token or its claims are of another type. The context key may be
+// given, otherwise the default "user" is used.
+func ClaimsFromContext[T jwt.Claims](c *fiber.Ctx, contextKey ...string) (T, bool) {
+	key := defaultContextKey
+	if len(contextKey) > 0 {
+		key = contextKey[0]
+	}
+	var claims T
+	token, ok := c.Locals(key).(*jwt.Token)
+	if !ok {
+		return claims, false
+	}
+	claims, ok = token.Claims.(T)
+	return claims, ok
+}
+
+// RequireAlg returns a handler which only continues the chain if the token stored by the middleware
+// was signed with the given algorithm, and responds with 401 otherwise. It narrows the accepted
+// algorithms for single routes without mounting another middleware instance.
+// The context key may be given, otherwise the default "user" is used.
+func RequireAlg(alg string, contextKey ...string) fiber.Handler {
+	key := defaultContextKey
+	if len(contextKey) > 0 {
+		key = contextKey[0]
+	}
+	return func(c *fiber.Ctx) error {
+		if token, ok := c.Locals(key).(*jwt.Token); ok {
+			if tokenAlg, ok := token.Header["alg"].(string); ok && tokenAlg == alg {
+				return c.Next()
+			}
 		}
-		return cfg.ErrorHandler(c, err)
+		return sendError(c, fiber.StatusUnauthorized, "invalid_token", "Invalid or expired JWT")
 	}
 }
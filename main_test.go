@@ -1,16 +1,45 @@
 package jwtware_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	cryptoecdsa "crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	cryptorsa "crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/go-jose/go-jose/v3"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/encryptcookie"
+	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/gofiber/fiber/v2/utils"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/valyala/fasthttp"
 
 	jwtware "github.com/gofiber/jwt/v4"
 )
@@ -342,3 +371,5406 @@ func customKeyfunc() jwt.Keyfunc {
 		return []byte(defaultSigningKey), nil
 	}
 }
+
+func TestJwkFromServerWithoutKID(t *testing.T) {
+	// Arrange
+	privateKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key.\nError:%s\n", err.Error())
+	}
+	server := keySetServer(rsaKeySet(privateKey, ""))
+	defer server.Close()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"}).SignedString(privateKey)
+	utils.AssertEqual(t, nil, err)
+
+	for _, allowMissingKID := range []bool{false, true} {
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			JWKSetURLs:            []string{server.URL},
+			JWKSetAllowMissingKID: allowMissingKID,
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		if allowMissingKID {
+			utils.AssertEqual(t, 200, resp.StatusCode)
+		} else {
+			utils.AssertEqual(t, 401, resp.StatusCode)
+		}
+	}
+}
+
+func TestJwkFromGzipServer(t *testing.T) {
+	// Arrange
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write([]byte(defaultKeySet))
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, nil, gz.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	for _, test := range append(rsa, ecdsa...) {
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			JWKSetURLs: []string{server.URL},
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+test.Token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+	}
+}
+
+func TestRawTokenFromContext(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	// Arrange
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: test.SigningMethod,
+			Key:    []byte(defaultSigningKey),
+		},
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString(jwtware.RawTokenFromContext(c))
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+test.Token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, test.Token, string(body))
+}
+
+func TestAlgFromContext(t *testing.T) {
+	t.Parallel()
+
+	server := keySetServer(defaultKeySet)
+	defer server.Close()
+
+	cases := []struct {
+		test   TestToken
+		config jwtware.Config
+	}{
+		{test: hamac[0], config: jwtware.Config{SigningKey: jwtware.SigningKey{JWTAlg: hamac[0].SigningMethod, Key: []byte(defaultSigningKey)}}},
+		{test: rsa[0], config: jwtware.Config{JWKSetURLs: []string{server.URL}, JWKSetIsolated: true}},
+		{test: ecdsa[2], config: jwtware.Config{JWKSetURLs: []string{server.URL}, JWKSetIsolated: true}},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		middleware := jwtware.NewMiddleware(tc.config)
+		app := fiber.New()
+		app.Use(middleware.Handler())
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString(jwtware.AlgFromContext(c) + "," + jwtware.AlgFromContext(c, "other"))
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.test.Token)
+
+		// Act
+		resp, err := app.Test(req)
+		middleware.Close()
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.test.SigningMethod+",", string(body))
+	}
+}
+
+func TestOptionalWithClaimsFromContext(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)}
+	valid, err := jwtware.Config{SigningKey: signingKey}.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+	expired, err := jwtware.Config{SigningKey: signingKey}.Sign(jwt.MapClaims{"sub": "1234567890", "exp": 1})
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		name          string
+		authorization string
+		claims        jwt.Claims
+		status        int
+		body          string
+	}{
+		{name: "anonymous", status: 200, body: "anonymous"},
+		{name: "other scheme", authorization: "Basic dXNlcjpwYXNz", status: 200, body: "anonymous"},
+		{name: "map claims", authorization: "Bearer " + valid, status: 200, body: "1234567890"},
+		{name: "struct claims", authorization: "Bearer " + valid, claims: &jwt.RegisteredClaims{}, status: 200, body: "1234567890"},
+		{name: "invalid", authorization: "Bearer " + expired, status: 401},
+		{name: "malformed", authorization: "Bearer a.b.c", status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: signingKey,
+			Claims:     tc.claims,
+			Optional:   true,
+			SuccessHandler: func(c *fiber.Ctx) error {
+				c.Set("X-Authenticated", "true")
+				return nil
+			},
+		}))
+		// A GraphQL endpoint, whose resolvers authorize every field themselves.
+		app.Post("/graphql", func(c *fiber.Ctx) error {
+			if claims, ok := jwtware.ClaimsFromContext[jwt.MapClaims](c); ok {
+				return c.SendString(claims["sub"].(string))
+			}
+			if claims, ok := jwtware.ClaimsFromContext[*jwt.RegisteredClaims](c); ok {
+				return c.SendString(claims.Subject)
+			}
+			return c.SendString("anonymous")
+		})
+
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ me { name } }"}`))
+		if tc.authorization != "" {
+			req.Header.Add("Authorization", tc.authorization)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+		if tc.status == 200 {
+			body, err := io.ReadAll(resp.Body)
+			utils.AssertEqual(t, nil, err)
+			utils.AssertEqual(t, tc.body, string(body), tc.name)
+			utils.AssertEqual(t, tc.body != "anonymous", resp.Header.Get("X-Authenticated") == "true", tc.name)
+		}
+	}
+}
+
+func TestDecodeUnverified(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	token, err := jwtware.Config{
+		SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte("unknown key")},
+	}.Sign(jwt.MapClaims{"sub": "1234567890", "exp": 1})
+	utils.AssertEqual(t, nil, err)
+
+	// Act
+	claims := jwt.MapClaims{}
+	err = jwtware.DecodeUnverified(token, claims)
+	registered := &jwt.RegisteredClaims{}
+	registeredErr := jwtware.DecodeUnverified(token, registered)
+	malformedErr := jwtware.DecodeUnverified("not a token", jwt.MapClaims{})
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, "1234567890", claims["sub"])
+	utils.AssertEqual(t, nil, registeredErr)
+	utils.AssertEqual(t, "1234567890", registered.Subject)
+	utils.AssertEqual(t, true, errors.Is(malformedErr, jwt.ErrTokenMalformed))
+}
+
+func TestSecp256k1Curve(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	curve := jwtware.Secp256k1Curve()
+	twoX, _ := new(big.Int).SetString("C6047F9441ED7D6D3045406E95C07CD85C778E4B8CEF3CA7ABAC09B95C709EE5", 16)
+	twoY, _ := new(big.Int).SetString("1AE168FEA63DC339A3C58419466CEAEEF7F632653266D0E1236431A950CFE52A", 16)
+	threeX, _ := new(big.Int).SetString("F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9", 16)
+
+	// Act
+	x2, y2 := curve.ScalarBaseMult([]byte{2})
+	dx, dy := curve.Double(curve.Params().Gx, curve.Params().Gy)
+	x3, _ := curve.Add(x2, y2, curve.Params().Gx, curve.Params().Gy)
+	ox, oy := curve.ScalarBaseMult(curve.Params().N.Bytes())
+
+	// Assert
+	utils.AssertEqual(t, 0, x2.Cmp(twoX))
+	utils.AssertEqual(t, 0, y2.Cmp(twoY))
+	utils.AssertEqual(t, 0, dx.Cmp(twoX))
+	utils.AssertEqual(t, 0, dy.Cmp(twoY))
+	utils.AssertEqual(t, 0, x3.Cmp(threeX))
+	utils.AssertEqual(t, 0, ox.Sign()+oy.Sign())
+	utils.AssertEqual(t, true, curve.IsOnCurve(x2, y2))
+	utils.AssertEqual(t, false, curve.IsOnCurve(x2, twoX))
+}
+
+// secp256k1KeySet returns a JWK Set with the public key of the given secp256k1 key.
+func secp256k1KeySet(key *cryptoecdsa.PublicKey, kid string) string {
+	return fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"secp256k1","kid":%q,"x":%q,"y":%q}]}`, kid,
+		base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))))
+}
+
+func TestES256KSigningKeys(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	key, err := cryptoecdsa.GenerateKey(jwtware.Secp256k1Curve(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, true, key.Curve.IsOnCurve(key.X, key.Y))
+
+	signed := jwt.NewWithClaims(jwtware.SigningMethodES256K, jwt.MapClaims{"sub": "1234567890"})
+	signed.Header["kid"] = "k1"
+	token, err := signed.SignedString(key)
+	utils.AssertEqual(t, nil, err)
+	otherKey, err := cryptoecdsa.GenerateKey(jwtware.Secp256k1Curve(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+
+	// The signature is a plain ECDSA signature, which crypto/ecdsa accepts as well.
+	dot := strings.LastIndex(token, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	utils.AssertEqual(t, nil, err)
+	hash := sha256.Sum256([]byte(token[:dot]))
+	utils.AssertEqual(t, true, cryptoecdsa.Verify(&key.PublicKey, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])))
+
+	cases := []struct {
+		key    *cryptoecdsa.PrivateKey
+		status int
+	}{
+		{key: key, status: 200},
+		{key: otherKey, status: 401},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKeys: map[string]jwtware.SigningKey{
+				"k1": {JWTAlg: jwtware.ES256K, Key: &tc.key.PublicKey},
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestES256KJWKSet(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	key, err := cryptoecdsa.GenerateKey(jwtware.Secp256k1Curve(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	otherKey, err := cryptoecdsa.GenerateKey(jwtware.Secp256k1Curve(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	server := keySetServer(secp256k1KeySet(&key.PublicKey, "k1"))
+	defer server.Close()
+
+	sign := func(method jwt.SigningMethod, signingKey interface{}) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(signingKey)
+		utils.AssertEqual(t, nil, err)
+		return signed
+	}
+	p256Key, err := cryptoecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		name   string
+		config jwtware.Config
+		token  string
+		status int
+	}{
+		{name: "JWK Set URL", config: jwtware.Config{JWKSetURLs: []string{server.URL}}, token: sign(jwtware.SigningMethodES256K, key), status: 200},
+		{name: "JWK Set URL, other key", config: jwtware.Config{JWKSetURLs: []string{server.URL}}, token: sign(jwtware.SigningMethodES256K, otherKey), status: 401},
+		{name: "JWK Set URL, other algorithm", config: jwtware.Config{JWKSetURLs: []string{server.URL}}, token: sign(jwt.SigningMethodES256, p256Key), status: 401},
+		{name: "JWK Set JSON", config: jwtware.Config{JWKSetJSON: []byte(secp256k1KeySet(&key.PublicKey, "k1"))}, token: sign(jwtware.SigningMethodES256K, key), status: 200},
+		{name: "JWK", config: jwtware.Config{SigningKey: jwtware.SigningKey{JWK: json.RawMessage(strings.TrimSuffix(strings.TrimPrefix(secp256k1KeySet(&key.PublicKey, "k1"), `{"keys":[`), "]}"))}}, token: sign(jwtware.SigningMethodES256K, key), status: 200},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+		app.Use(jwtware.New(tc.config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestContextKeyFunc(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	// Arrange
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: test.SigningMethod,
+			Key:    []byte(defaultSigningKey),
+		},
+		ContextKeyFunc: func(token *jwt.Token) string {
+			sub, _ := token.Claims.GetSubject()
+			return "user:" + sub
+		},
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		if _, ok := c.Locals("user:1234567890").(*jwt.Token); !ok {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		if c.Locals("user") != nil {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+test.Token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestJwtFromMultipleQueries(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	invalid := hamac[1].Token
+
+	cases := []struct {
+		query  string
+		status int
+	}{
+		{query: "access_token=" + test.Token, status: 200},
+		{query: "jwt=" + test.Token, status: 200},
+		{query: "access_token=" + test.Token + "&jwt=" + invalid, status: 200},
+		{query: "access_token=" + invalid + "&jwt=" + test.Token, status: 401},
+		{query: "", status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: "query:access_token,query:jwt",
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok?"+tc.query, nil)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestJwtFromQueryPercentEncoded(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	cases := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{name: "plain", query: test.Token, status: 200},
+		{name: "encoded once", query: url.QueryEscape(test.Token), status: 200},
+		{name: "dots encoded twice", query: strings.ReplaceAll(test.Token, ".", "%252E"), status: 200},
+		{name: "encoded twice", query: url.QueryEscape(url.QueryEscape(strings.ReplaceAll(test.Token, ".", "%2E"))), status: 401},
+		{name: "not a token", query: "abc%252Edef", status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: "query:token",
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok?token="+tc.query, nil)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestRejectAlgNone(t *testing.T) {
+	t.Parallel()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "1234567890"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	utils.AssertEqual(t, nil, err)
+
+	for _, allowAlgNone := range []bool{false, true} {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			KeyFunc: func(t *jwt.Token) (interface{}, error) {
+				return jwt.UnsafeAllowNoneSignatureType, nil
+			},
+			UnsafeAllowAlgNone: allowAlgNone,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				if errors.Is(err, jwtware.ErrJWTAlgNone) {
+					return c.SendStatus(fiber.StatusForbidden)
+				}
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		if allowAlgNone {
+			utils.AssertEqual(t, 200, resp.StatusCode)
+		} else {
+			utils.AssertEqual(t, 403, resp.StatusCode)
+		}
+	}
+}
+
+func TestKIDValidator(t *testing.T) {
+	t.Parallel()
+
+	errWrongEnv := errors.New("not a production key")
+	signingKey := []byte(defaultSigningKey)
+	cases := []struct {
+		kid    interface{}
+		status int
+	}{
+		{kid: "prod-1", status: 200},
+		{kid: "staging-1", status: 403},
+		{kid: nil, status: 403},
+		{kid: 1, status: 403},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"})
+		if tc.kid != nil {
+			token.Header["kid"] = tc.kid
+		}
+		signed, err := token.SignedString(signingKey)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKeys: map[string]jwtware.SigningKey{
+				"prod-1":    {JWTAlg: jwtware.HS256, Key: signingKey},
+				"staging-1": {JWTAlg: jwtware.HS256, Key: signingKey},
+			},
+			KIDValidator: func(kid string) error {
+				if !strings.HasPrefix(kid, "prod-") {
+					return errWrongEnv
+				}
+				return nil
+			},
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				if errors.Is(err, jwtware.ErrJWTKID) && errors.Is(err, errWrongEnv) {
+					return c.SendStatus(fiber.StatusForbidden)
+				}
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, fmt.Sprint(tc.kid))
+	}
+}
+
+func TestJwkSetKeySelector(t *testing.T) {
+	// Arrange
+	firstKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	lastKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+
+	firstServer := keySetServer(rsaKeySet(firstKey, "shared"))
+	defer firstServer.Close()
+	lastServer := keySetServer(rsaKeySet(lastKey, "shared"))
+	defer lastServer.Close()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+	token.Header["kid"] = "shared"
+	signed, err := token.SignedString(lastKey)
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		selector jwtware.KeySelector
+		status   int
+	}{
+		{selector: jwtware.KeySelectorFirst, status: 401},
+		{selector: jwtware.KeySelectorLast, status: 200},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			JWKSetURLs:        []string{firstServer.URL, lastServer.URL},
+			JWKSetKeySelector: tc.selector,
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestJwkSetKeySelectorRefresh(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	otherKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	signingKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+
+	var otherFetches int32
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&otherFetches, 1)
+		_, _ = w.Write([]byte(rsaKeySet(otherKey, "other")))
+	}))
+	defer otherServer.Close()
+	signingServer := keySetServer(rsaKeySet(signingKey, "signing"))
+	defer signingServer.Close()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+	token.Header["kid"] = "signing"
+	signed, err := token.SignedString(signingKey)
+	utils.AssertEqual(t, nil, err)
+
+	middleware := jwtware.NewMiddleware(jwtware.Config{
+		JWKSetURLs:        []string{otherServer.URL, signingServer.URL},
+		JWKSetKeySelector: jwtware.KeySelectorFirst,
+	})
+	defer middleware.Close()
+	app := fiber.New()
+	app.Use(middleware.Handler())
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+	}
+	// The JWK Set without the "kid" is not refreshed for it.
+	utils.AssertEqual(t, int32(1), atomic.LoadInt32(&otherFetches))
+}
+
+// rsaKeySet returns a JWK Set containing the public part of the given RSA key.
+func rsaKeySet(key *cryptorsa.PrivateKey, kid string) string {
+	return fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"%s","e":"%s","n":"%s"}]}`,
+		kid,
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+	)
+}
+
+// keySetServer returns a HTTP test server serving the given JWK Set.
+func keySetServer(keySet string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(keySet))
+	}))
+}
+
+func TestJWKSetStreaming(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	privateKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	key := strings.TrimSuffix(strings.TrimPrefix(rsaKeySet(privateKey, "signing"), `{"keys":[`), "]}")
+	keys := make([]string, 0, 201)
+	for i := 0; i < 100; i++ {
+		keys = append(keys,
+			fmt.Sprintf(`{"kty":"oct","kid":"hmac-%d","k":"c2VjcmV0"}`, i),
+			fmt.Sprintf(`{"kty":"oct","kid":"enc-%d","use":"enc","k":"c2VjcmV0"}`, i))
+	}
+	keys = append(keys, key)
+	server := keySetServer(`{"issuer": {"name": "example", "tags": ["a", {"b": []}]}, "keys": [` +
+		strings.Join(keys, ",\n  ") + `], "expires": 1700000000}`)
+	defer server.Close()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+	token.Header["kid"] = "signing"
+	signed, err := token.SignedString(privateKey)
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		JWKSetURLs:      []string{server.URL},
+		JWKSetStreaming: true,
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+signed)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestTrustFunc(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+
+	for _, withToken := range []bool{true, false} {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte("not the signing key"),
+			},
+			TrustFunc: func(c *fiber.Ctx) bool {
+				return true
+			},
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			_, ok := c.Locals("user").(*jwt.Token)
+			if ok != withToken {
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		if withToken {
+			req.Header.Add("Authorization", "Bearer "+test.Token)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+	}
+}
+
+func TestJwtEncrypted(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	decryptionKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: jose.RSA_OAEP_256, Key: &decryptionKey.PublicKey}, nil)
+	utils.AssertEqual(t, nil, err)
+	encrypted, err := encrypter.Encrypt([]byte(test.Token))
+	utils.AssertEqual(t, nil, err)
+	token, err := encrypted.CompactSerialize()
+	utils.AssertEqual(t, nil, err)
+
+	for _, withKey := range []bool{true, false} {
+		// Arrange
+		app := fiber.New()
+
+		config := jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				if errors.Is(err, jwtware.ErrJWEDecryptionKey) {
+					return c.SendStatus(fiber.StatusBadRequest)
+				}
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}
+		if withKey {
+			config.DecryptionKey = decryptionKey
+		}
+		app.Use(jwtware.New(config))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		if withKey {
+			utils.AssertEqual(t, 200, resp.StatusCode)
+		} else {
+			utils.AssertEqual(t, 400, resp.StatusCode)
+		}
+	}
+}
+
+func TestJwtEncryptedAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	decryptionKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	encrypt := func(alg jose.KeyAlgorithm, enc jose.ContentEncryption) string {
+		encrypter, err := jose.NewEncrypter(enc, jose.Recipient{Algorithm: alg, Key: &decryptionKey.PublicKey}, nil)
+		utils.AssertEqual(t, nil, err)
+		encrypted, err := encrypter.Encrypt([]byte(test.Token))
+		utils.AssertEqual(t, nil, err)
+		token, err := encrypted.CompactSerialize()
+		utils.AssertEqual(t, nil, err)
+		return token
+	}
+
+	cases := []struct {
+		name   string
+		token  string
+		algs   []string
+		status int
+	}{
+		{name: "RSA-OAEP", token: encrypt(jose.RSA_OAEP, jose.A128GCM), status: 200},
+		{name: "RSA1_5", token: encrypt(jose.RSA1_5, jose.A256GCM), status: 400},
+		{name: "CBC", token: encrypt(jose.RSA_OAEP_256, jose.A128CBC_HS256), status: 400},
+		{name: "RSA1_5 allowed", token: encrypt(jose.RSA1_5, jose.A256GCM), algs: []string{string(jose.RSA1_5)}, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			DecryptionKey:        decryptionKey,
+			DecryptionAlgorithms: tc.algs,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				if errors.Is(err, jwtware.ErrJWEAlgorithm) {
+					return c.SendStatus(fiber.StatusBadRequest)
+				}
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestRejectFutureIssued(t *testing.T) {
+	t.Parallel()
+
+	future := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{IssuedAt: future}).SignedString([]byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		claims jwt.Claims
+		reject bool
+		leeway time.Duration
+		status int
+	}{
+		{claims: jwt.MapClaims{}, reject: false, status: 200},
+		{claims: jwt.MapClaims{}, reject: true, status: 401},
+		{claims: &jwt.RegisteredClaims{}, reject: true, status: 401},
+		{claims: &jwt.RegisteredClaims{}, reject: true, leeway: 2 * time.Hour, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			Claims:             tc.claims,
+			Leeway:             tc.leeway,
+			RejectFutureIssued: tc.reject,
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestJwkFromJKUHeader(t *testing.T) {
+	// Arrange
+	privateKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+
+	keySet := rsaKeySet(privateKey, "gofiber-jku")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(keySet))
+	}))
+	defer server.Close()
+	insecureServer := keySetServer(keySet)
+	defer insecureServer.Close()
+
+	cases := []struct {
+		jku          string
+		allowedHosts []string
+		status       int
+	}{
+		{jku: server.URL, allowedHosts: []string{"127.0.0.1"}, status: 200},
+		{jku: server.URL, allowedHosts: []string{"example.com"}, status: 401},
+		{jku: insecureServer.URL, allowedHosts: []string{"127.0.0.1"}, status: 401},
+	}
+
+	for _, tc := range cases {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = "gofiber-jku"
+		token.Header["jku"] = tc.jku
+		signed, err := token.SignedString(privateKey)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			AllowedJKUHosts:  tc.allowedHosts,
+			JWKSetHTTPClient: server.Client(),
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.jku)
+	}
+}
+
+func TestJKUBindsKeySource(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	keys := make([]*cryptorsa.PrivateKey, 5)
+	for i := range keys {
+		var err error
+		keys[i], err = cryptorsa.GenerateKey(rand.Reader, 2048)
+		utils.AssertEqual(t, nil, err)
+	}
+	jwk := func(key *cryptorsa.PrivateKey, alg string) string {
+		return fmt.Sprintf(`{"kty":"RSA","kid":"shared","alg":"%s","e":"%s","n":"%s"}`, alg,
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()))
+	}
+	// Every source publishes the "kid" "shared", the static one and "/other" twice, so that they are indexed.
+	static := `{"keys":[` + jwk(keys[0], jwtware.RS256) + "," + jwk(keys[1], jwtware.PS256) + `]}`
+	sets := map[string]string{
+		"/single": `{"keys":[` + jwk(keys[2], jwtware.RS256) + `]}`,
+		"/other":  `{"keys":[` + jwk(keys[3], jwtware.RS256) + "," + jwk(keys[4], jwtware.PS256) + `]}`,
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sets[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		JWKSetJSON:       []byte(static),
+		AllowedJKUHosts:  []string{"127.0.0.1"},
+		JWKSetHTTPClient: server.Client(),
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	cases := []struct {
+		name   string
+		jku    string
+		key    *cryptorsa.PrivateKey
+		status int
+	}{
+		{name: "jku key", jku: "/single", key: keys[2], status: 200},
+		{name: "static key with jku", jku: "/single", key: keys[0], status: 401},
+		{name: "other jku key", jku: "/other", key: keys[3], status: 200},
+		{name: "other jku key with jku", jku: "/single", key: keys[3], status: 401},
+		{name: "static key", key: keys[0], status: 200},
+		{name: "jku key without jku", key: keys[2], status: 401},
+	}
+
+	for _, tc := range cases {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = "shared"
+		if tc.jku != "" {
+			token.Header["jku"] = server.URL + tc.jku
+		}
+		signed, err := token.SignedString(tc.key)
+		utils.AssertEqual(t, nil, err)
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestJKUFetches(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	privateKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+
+	keySet := rsaKeySet(privateKey, "gofiber-jku")
+	var fetches int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		// Slow down the fetch, so that concurrent requests overlap with it.
+		time.Sleep(50 * time.Millisecond)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(keySet))
+	}))
+	defer server.Close()
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		AllowedJKUHosts:  []string{"127.0.0.1"},
+		JWKSetHTTPClient: server.Client(),
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for _, path := range []string{"/jwks", "/missing"} {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = "gofiber-jku"
+		token.Header["jku"] = server.URL + path
+		signed, err := token.SignedString(privateKey)
+		utils.AssertEqual(t, nil, err)
+		atomic.StoreInt32(&fetches, 0)
+
+		// Act
+		var wg sync.WaitGroup
+		statuses := make(chan int, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest("GET", "/ok", nil)
+				req.Header.Add("Authorization", "Bearer "+signed)
+				if resp, err := app.Test(req); err == nil {
+					statuses <- resp.StatusCode
+				}
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+
+		// Assert
+		utils.AssertEqual(t, int32(1), atomic.LoadInt32(&fetches), path)
+		expected := fiber.StatusOK
+		if path == "/missing" {
+			expected = fiber.StatusUnauthorized
+		}
+		count := 0
+		for status := range statuses {
+			utils.AssertEqual(t, expected, status, path)
+			count++
+		}
+		utils.AssertEqual(t, 10, count, path)
+	}
+}
+
+func TestOnJWKSRefresh(t *testing.T) {
+	// Arrange
+	server := keySetServer(defaultKeySet)
+	defer server.Close()
+
+	var refreshedURL string
+	var refreshErr error
+	calls := 0
+
+	// Act
+	jwtware.New(jwtware.Config{
+		JWKSetURLs: []string{server.URL},
+		OnJWKSRefresh: func(url string, err error, duration time.Duration) {
+			refreshedURL = url
+			refreshErr = err
+			calls++
+		},
+	})
+
+	// Assert
+	utils.AssertEqual(t, 1, calls)
+	utils.AssertEqual(t, server.URL, refreshedURL)
+	utils.AssertEqual(t, nil, refreshErr)
+}
+
+func TestBeforeNext(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+
+	for _, fail := range []bool{false, true} {
+		// Arrange
+		app := fiber.New()
+
+		fail := fail
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			BeforeNext: func(c *fiber.Ctx, token *jwt.Token) error {
+				if fail {
+					return errors.New("rejected")
+				}
+				c.Set("X-Subject", "1234567890")
+				return nil
+			},
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+test.Token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		if fail {
+			utils.AssertEqual(t, 401, resp.StatusCode)
+		} else {
+			utils.AssertEqual(t, 200, resp.StatusCode)
+			utils.AssertEqual(t, "1234567890", resp.Header.Get("X-Subject"))
+		}
+	}
+}
+
+func TestMaxTokenLength(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+
+	cases := []struct {
+		maxTokenLength int
+		status         int
+	}{
+		{maxTokenLength: 0, status: 200},
+		{maxTokenLength: -1, status: 200},
+		{maxTokenLength: len(test.Token), status: 200},
+		{maxTokenLength: len(test.Token) - 1, status: 413},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			MaxTokenLength: tc.maxTokenLength,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				if errors.Is(err, jwtware.ErrJWTTooLarge) {
+					return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+				}
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+test.Token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestKnownKIDs(t *testing.T) {
+	// Arrange
+	server := keySetServer(defaultKeySet)
+	defer server.Close()
+
+	// Act
+	middleware := jwtware.NewMiddleware(jwtware.Config{
+		JWKSetURLs: []string{server.URL},
+	})
+	defer middleware.Close()
+	jwks := middleware.KnownKIDs()
+	signingKey := jwtware.NewMiddleware(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+	}).KnownKIDs()
+	keyFunc := jwtware.NewMiddleware(jwtware.Config{
+		KeyFunc: customKeyfunc(),
+	}).KnownKIDs()
+
+	// Assert
+	utils.AssertEqual(t, []jwtware.KeyInfo{
+		{KID: "gofiber-p-256", Source: server.URL},
+		{KID: "gofiber-p-384", Source: server.URL},
+		{KID: "gofiber-p-521", Source: server.URL},
+		{KID: "gofiber-rsa", Source: server.URL},
+	}, jwks)
+	utils.AssertEqual(t, []jwtware.KeyInfo{{Algorithm: jwtware.HS256}}, signingKey)
+	utils.AssertEqual(t, 0, len(keyFunc))
+}
+
+func TestRequireAlg(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range hamac {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				Key: []byte(defaultSigningKey),
+			},
+		}))
+
+		app.Get("/ok", jwtware.RequireAlg(jwtware.HS256), func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+test.Token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		if test.SigningMethod == jwtware.HS256 {
+			utils.AssertEqual(t, 200, resp.StatusCode)
+		} else {
+			utils.AssertEqual(t, 401, resp.StatusCode)
+		}
+	}
+}
+
+func TestMiddlewareClose(t *testing.T) {
+	// Arrange
+	server := keySetServer(defaultKeySet)
+	defer server.Close()
+
+	test := rsa[0]
+	middleware := jwtware.NewMiddleware(jwtware.Config{
+		JWKSetURLs: []string{server.URL},
+	})
+	app := fiber.New()
+	app.Use(middleware.Handler())
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	// Act
+	middleware.Close()
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+test.Token)
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestNewWithContext(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(defaultKeySet))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app := fiber.New()
+	app.Use(jwtware.NewWithContext(ctx, jwtware.Config{
+		JWKSetURLs:            []string{server.URL},
+		JWKSetIsolated:        true,
+		JWKSetRefreshInterval: 10 * time.Millisecond,
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	// Act
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	afterCancel := atomic.LoadInt32(&requests)
+	time.Sleep(50 * time.Millisecond)
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+rsa[0].Token)
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, true, afterCancel > 1)
+	utils.AssertEqual(t, afterCancel, atomic.LoadInt32(&requests))
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestSharedJWKSets(t *testing.T) {
+	t.Parallel()
+
+	for _, isolated := range []bool{false, true} {
+		// Arrange
+		var requests int32
+		servers := make([]*httptest.Server, 2)
+		for i := range servers {
+			servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				_, _ = w.Write([]byte(defaultKeySet))
+			}))
+			defer servers[i].Close()
+		}
+
+		// Act
+		first := jwtware.NewMiddleware(jwtware.Config{
+			JWKSetURLs:     []string{servers[0].URL, servers[1].URL},
+			JWKSetIsolated: isolated,
+		})
+		second := jwtware.NewMiddleware(jwtware.Config{
+			JWKSetURLs:     []string{servers[1].URL, servers[0].URL},
+			JWKSetIsolated: isolated,
+		})
+		first.Close()
+		third := jwtware.NewMiddleware(jwtware.Config{
+			JWKSetURLs:     []string{servers[0].URL, servers[1].URL},
+			JWKSetIsolated: isolated,
+		})
+		second.Close()
+		third.Close()
+
+		// Assert
+		expected := int32(2)
+		if isolated {
+			expected = 6
+		}
+		utils.AssertEqual(t, expected, atomic.LoadInt32(&requests))
+	}
+}
+
+func TestJwtFromSession(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	// Arrange
+	store := session.New()
+	app := fiber.New()
+
+	app.Post("/login", func(c *fiber.Ctx) error {
+		sess, err := store.Get(c)
+		if err != nil {
+			return err
+		}
+		sess.Set("token", test.Token)
+		return sess.Save()
+	})
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: test.SigningMethod,
+			Key:    []byte(defaultSigningKey),
+		},
+		TokenLookup:  "session:token",
+		SessionStore: store,
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	// Act
+	resp, err := app.Test(httptest.NewRequest("GET", "/ok", nil))
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 401, resp.StatusCode)
+
+	// Act
+	resp, err = app.Test(httptest.NewRequest("POST", "/login", nil))
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	resp, err = app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestExpectedTokenType(t *testing.T) {
+	t.Parallel()
+
+	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"})
+	accessToken.Header["typ"] = "at+jwt"
+	signed, err := accessToken.SignedString([]byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		token  string
+		status int
+	}{
+		{token: signed, status: 200},
+		{token: hamac[0].Token, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			ExpectedTokenType: "application/at+JWT",
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestRejectCurveMismatch(t *testing.T) {
+	t.Parallel()
+
+	test := ecdsa[0]
+	key, err := cryptoecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+
+	// Arrange
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		KeyFunc: func(t *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		},
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			if errors.Is(err, jwtware.ErrJWTCurve) {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+			return c.SendStatus(fiber.StatusUnauthorized)
+		},
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+test.Token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 400, resp.StatusCode)
+}
+
+type testFailureLimiter struct {
+	mux      sync.Mutex
+	failures map[string]int
+}
+
+func (l *testFailureLimiter) Allow(key string) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.failures[key] < 2
+}
+
+func (l *testFailureLimiter) Failure(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.failures[key]++
+}
+
+func TestFailureLimiter(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	// Arrange
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: test.SigningMethod,
+			Key:    []byte("not the signing key"),
+		},
+		FailureLimiter: &testFailureLimiter{failures: make(map[string]int)},
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for _, status := range []int{401, 401, 429} {
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+test.Token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, status, resp.StatusCode)
+	}
+}
+
+func TestJwkFromJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range append(rsa, ecdsa...) {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			JWKSetJSON: json.RawMessage(defaultKeySet),
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+test.Token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+	}
+}
+
+func TestJwtFromLenientTokenLookup(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	// Arrange
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: test.SigningMethod,
+			Key:    []byte(defaultSigningKey),
+		},
+		TokenLookup: " Query : token ",
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok?token="+test.Token, nil)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"})
+	token.Header["kid"] = "gofiber-hmac"
+	signed, err := token.SignedString([]byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+
+	// Arrange
+	type ctxKey struct{}
+	calls := 0
+	var requestValue interface{}
+	now := time.Now()
+	middleware := jwtware.NewMiddleware(jwtware.Config{
+		KeyProvider: func(ctx context.Context, kid string) (interface{}, error) {
+			calls++
+			requestValue = ctx.Value(ctxKey{})
+			if kid != "gofiber-hmac" {
+				return nil, errors.New("unknown kid")
+			}
+			return []byte(defaultSigningKey), nil
+		},
+		KeyProviderCacheTTL: time.Minute,
+		TimeFunc: func() time.Time {
+			return now
+		},
+	})
+	defer middleware.Close()
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(context.WithValue(c.UserContext(), ctxKey{}, "request"))
+		return c.Next()
+	})
+	app.Use(middleware.Handler())
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	steps := []struct {
+		name  string
+		setup func()
+		calls int
+	}{
+		{name: "first use", calls: 1},
+		{name: "cached", calls: 1},
+		{name: "invalidated", setup: func() { middleware.InvalidateKey("gofiber-hmac") }, calls: 2},
+		{name: "cached again", calls: 2},
+		{name: "expired", setup: func() { now = now.Add(2 * time.Minute) }, calls: 3},
+	}
+
+	for _, step := range steps {
+		if step.setup != nil {
+			step.setup()
+		}
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode, step.name)
+		utils.AssertEqual(t, step.calls, calls, step.name)
+		utils.AssertEqual(t, "request", requestValue, step.name)
+	}
+}
+
+func TestKeyProviderCacheSize(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	calls := map[string]int{}
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		KeyProvider: func(ctx context.Context, kid string) (interface{}, error) {
+			calls[kid]++
+			return []byte(defaultSigningKey), nil
+		},
+		KeyProviderCacheSize: 2,
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for _, kid := range []string{"a", "b", "c", "a"} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString([]byte(defaultSigningKey))
+		utils.AssertEqual(t, nil, err)
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+	}
+	// "a" expires first, so it was dropped for "c" and fetched again.
+	utils.AssertEqual(t, 2, calls["a"])
+	utils.AssertEqual(t, 1, calls["b"])
+	utils.AssertEqual(t, 1, calls["c"])
+}
+
+func TestKeyProviderMisses(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	var calls sync.Map
+	count := func(kid string) int32 {
+		n, _ := calls.LoadOrStore(kid, new(int32))
+		return atomic.LoadInt32(n.(*int32))
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	var startOnce sync.Once
+	var now atomic.Value
+	now.Store(time.Now())
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		KeyProvider: func(ctx context.Context, kid string) (interface{}, error) {
+			n, _ := calls.LoadOrStore(kid, new(int32))
+			atomic.AddInt32(n.(*int32), 1)
+			if kid != "slow" {
+				return nil, errors.New("unknown kid")
+			}
+			startOnce.Do(func() { close(started) })
+			<-release
+			return []byte(defaultSigningKey), nil
+		},
+		TimeFunc: func() time.Time {
+			return now.Load().(time.Time)
+		},
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+	request := func(kid string) int {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString([]byte(defaultSigningKey))
+		utils.AssertEqual(t, nil, err)
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+		resp, err := app.Test(req)
+		utils.AssertEqual(t, nil, err)
+		return resp.StatusCode
+	}
+
+	// Act & Assert: an unknown "kid" is only looked up again once its failure expired.
+	utils.AssertEqual(t, 401, request("unknown"))
+	utils.AssertEqual(t, 401, request("unknown"))
+	utils.AssertEqual(t, int32(1), count("unknown"))
+	now.Store(now.Load().(time.Time).Add(time.Minute))
+	utils.AssertEqual(t, 401, request("unknown"))
+	utils.AssertEqual(t, int32(2), count("unknown"))
+
+	// Act & Assert: concurrent lookups of the same "kid" share one call.
+	var wg sync.WaitGroup
+	statuses := make([]int, 10)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = request("slow")
+		}(i)
+	}
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, status := range statuses {
+		utils.AssertEqual(t, 200, status)
+	}
+	utils.AssertEqual(t, int32(1), count("slow"))
+}
+
+func TestAdditionalTokens(t *testing.T) {
+	t.Parallel()
+
+	access := hamac[0]
+	device := hamac[1]
+
+	cases := []struct {
+		withDevice bool
+		optional   bool
+		status     int
+	}{
+		{withDevice: true, optional: false, status: 200},
+		{withDevice: false, optional: false, status: 401},
+		{withDevice: false, optional: true, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: access.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			AdditionalTokens: []jwtware.AdditionalToken{
+				{
+					Config: jwtware.Config{
+						SigningKey: jwtware.SigningKey{
+							JWTAlg: device.SigningMethod,
+							Key:    []byte(defaultSigningKey),
+						},
+						TokenLookup: "cookie:device",
+						ContextKey:  "device",
+					},
+					Optional: tc.optional,
+				},
+			},
+		}))
+
+		withDevice := tc.withDevice
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			if _, ok := c.Locals("user").(*jwt.Token); !ok {
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+			if _, ok := c.Locals("device").(*jwt.Token); ok != withDevice {
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+access.Token)
+		if tc.withDevice {
+			req.AddCookie(&http.Cookie{Name: "device", Value: device.Token})
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func BenchmarkJwtFromHeader(b *testing.B) {
+	test := hamac[0]
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: test.SigningMethod,
+			Key:    []byte(defaultSigningKey),
+		},
+		Claims: &jwt.RegisteredClaims{},
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	h := app.Handler()
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(fiber.MethodGet)
+	fctx.Request.SetRequestURI("/ok")
+	fctx.Request.Header.Set(fiber.HeaderAuthorization, "Bearer "+test.Token)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		h(fctx)
+	}
+
+	utils.AssertEqual(b, fiber.StatusOK, fctx.Response.StatusCode())
+}
+
+func TestUnencodedPayload(t *testing.T) {
+	t.Parallel()
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","b64":false,"crit":["b64"]}`))
+	signingInput := header + `.{"sub":"1234567890"}`
+	signature, err := jwt.SigningMethodHS256.Sign(signingInput, []byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+	token := signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	for _, allow := range []bool{true, false} {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup:           "header:X-Token",
+			AllowUnencodedPayload: allow,
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			sub, err := c.Locals("user").(*jwt.Token).Claims.GetSubject()
+			if err != nil {
+				return err
+			}
+			return c.SendString(sub)
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("X-Token", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		if allow {
+			utils.AssertEqual(t, 200, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			utils.AssertEqual(t, nil, err)
+			utils.AssertEqual(t, "1234567890", string(body))
+		} else {
+			utils.AssertEqual(t, 401, resp.StatusCode)
+		}
+	}
+}
+
+func TestUnencodedPayloadParserOptions(t *testing.T) {
+	t.Parallel()
+
+	sign := func(alg string, method jwt.SigningMethod, payload string) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","b64":false,"crit":["b64"]}`))
+		signature, err := method.Sign(header+"."+payload, []byte(defaultSigningKey))
+		utils.AssertEqual(t, nil, err)
+		return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+
+	cases := []struct {
+		name    string
+		token   string
+		options []jwt.ParserOption
+		status  int
+	}{
+		{name: "valid method", token: sign(jwtware.HS256, jwt.SigningMethodHS256, `{"iss":"me"}`), options: []jwt.ParserOption{jwt.WithValidMethods([]string{jwtware.HS256})}, status: 200},
+		{name: "invalid method", token: sign(jwtware.HS384, jwt.SigningMethodHS384, `{"iss":"me"}`), options: []jwt.ParserOption{jwt.WithValidMethods([]string{jwtware.HS256})}, status: 401},
+		{name: "expected issuer", token: sign(jwtware.HS256, jwt.SigningMethodHS256, `{"iss":"me"}`), options: []jwt.ParserOption{jwt.WithIssuer("me")}, status: 200},
+		{name: "other issuer", token: sign(jwtware.HS256, jwt.SigningMethodHS256, `{"iss":"other"}`), options: []jwt.ParserOption{jwt.WithIssuer("me")}, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey:            jwtware.SigningKey{Key: []byte(defaultSigningKey)},
+			AllowUnencodedPayload: true,
+			ParserOptions:         tc.options,
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestConfigSign(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS512,
+			Key:    []byte(defaultSigningKey),
+		},
+	}
+	token, err := config.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(config))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+
+	_, err = jwtware.Config{JWKSetJSON: json.RawMessage(defaultKeySet)}.Sign(jwt.MapClaims{})
+	utils.AssertEqual(t, true, errors.Is(err, jwtware.ErrJWTSigningKey))
+}
+
+func TestRequireScopes(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{
+		JWTAlg: jwtware.HS256,
+		Key:    []byte(defaultSigningKey),
+	}
+
+	cases := []struct {
+		claims     jwt.MapClaims
+		scopeClaim string
+		status     int
+	}{
+		{claims: jwt.MapClaims{"scope": "read write"}, status: 200},
+		{claims: jwt.MapClaims{"scope": "read"}, status: 403},
+		{claims: jwt.MapClaims{"scp": []string{"write", "read"}}, scopeClaim: "scp", status: 200},
+		{claims: jwt.MapClaims{"scp": []string{"read"}}, scopeClaim: "scp", status: 403},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		config := jwtware.Config{
+			SigningKey: signingKey,
+			ScopeClaim: tc.scopeClaim,
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		middleware := jwtware.NewMiddleware(config)
+		app := fiber.New()
+		app.Use(middleware.Handler())
+		app.Get("/ok", middleware.RequireScopes("read", "write"), func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestJwkFromFailingServer(t *testing.T) {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>Internal Server Error</html>", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	defer func() {
+		// Assert
+		err := recover()
+		if err == nil {
+			t.Fatalf("Middleware should panic on a failing JWK Set URL")
+		}
+		utils.AssertEqual(t, true, strings.Contains(fmt.Sprint(err), "got HTTP 500 from "+server.URL))
+	}()
+
+	// Act
+	jwtware.New(jwtware.Config{
+		JWKSetURLs: []string{server.URL},
+	})
+}
+
+func TestDefaultErrorHandlerJSON(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte("not the signing key"),
+		},
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	cases := []struct {
+		accept  string
+		missing bool
+		body    string
+	}{
+		{accept: "application/json", body: `{"error":"invalid_token","message":"Invalid or expired JWT"}`},
+		{accept: "*/*", body: "Invalid or expired JWT"},
+		{accept: "", body: "Invalid or expired JWT"},
+		{accept: "application/json", missing: true, body: `{"error":"invalid_request","message":"Missing or malformed JWT"}`},
+		{accept: "", missing: true, body: "Missing or malformed JWT"},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/ok", nil)
+		if !tc.missing {
+			req.Header.Add("Authorization", "Bearer "+hamac[0].Token)
+		}
+		if tc.accept != "" {
+			req.Header.Add("Accept", tc.accept)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 401, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.body, string(body))
+	}
+}
+
+func TestJwtFromFallbackHeader(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	// Arrange
+	app := fiber.New()
+
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: test.SigningMethod,
+			Key:    []byte(defaultSigningKey),
+		},
+		TokenLookup: "header:Authorization,header:X-Forwarded-Authorization",
+		AuthScheme:  "Bearer",
+	}))
+
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("X-Forwarded-Authorization", "Bearer "+test.Token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestRejectAlgConfusion(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	utils.AssertEqual(t, nil, err)
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	// The attacker signs with HS256, using the public key as the HMAC secret.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"})
+	forged.Header["kid"] = "gofiber-rsa"
+	forgedToken, err := forged.SignedString(publicKeyPEM)
+	utils.AssertEqual(t, nil, err)
+
+	legit := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+	legit.Header["kid"] = "gofiber-rsa"
+	legitToken, err := legit.SignedString(privateKey)
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		token  string
+		status int
+	}{
+		{token: legitToken, status: 200},
+		{token: forgedToken, status: 400},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKeys: map[string]jwtware.SigningKey{
+				"gofiber-rsa": {Key: &privateKey.PublicKey},
+			},
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				if errors.Is(err, jwtware.ErrJWTAlg) {
+					return c.SendStatus(fiber.StatusBadRequest)
+				}
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestClaimsToLocals(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+		ClaimsToLocals: map[string]string{
+			"sub":       "userID",
+			"tenant_id": "tenant",
+		},
+	}
+	token, err := config.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(config))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		utils.AssertEqual(t, "1234567890", c.Locals("userID"))
+		utils.AssertEqual(t, nil, c.Locals("tenant"))
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestNotBefore(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{
+		JWTAlg: jwtware.HS256,
+		Key:    []byte(defaultSigningKey),
+	}
+	future := time.Now().Add(30 * time.Second).Unix()
+
+	cases := []struct {
+		leeway time.Duration
+		status int
+		err    error
+	}{
+		{leeway: 0, status: 401, err: jwtware.ErrJWTNotYetValid},
+		{leeway: time.Minute, status: 200, err: nil},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var handlerErr error
+		config := jwtware.Config{
+			SigningKey: signingKey,
+			Leeway:     tc.leeway,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				handlerErr = err
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}
+		token, err := config.Sign(jwt.MapClaims{"nbf": future})
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+		if tc.err != nil {
+			utils.AssertEqual(t, true, errors.Is(handlerErr, tc.err))
+			utils.AssertEqual(t, true, errors.Is(handlerErr, jwt.ErrTokenNotValidYet))
+			utils.AssertEqual(t, false, errors.Is(handlerErr, jwt.ErrTokenExpired))
+		}
+	}
+}
+
+func TestDefaultErrorHandlerNotYetValid(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+	}
+	token, err := config.Sign(jwt.MapClaims{"nbf": time.Now().Add(time.Hour).Unix()})
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(config))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 401, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, "JWT not valid yet", string(body))
+}
+
+func TestJSONUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	var calls int32
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+		JSONUnmarshal: func(data []byte, v interface{}) error {
+			atomic.AddInt32(&calls, 1)
+			return json.Unmarshal(data, v)
+		},
+	}
+	token, err := config.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(config))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+	utils.AssertEqual(t, true, atomic.LoadInt32(&calls) > 0)
+}
+
+func TestStrictJSON(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		header  string
+		payload string
+		strict  bool
+		status  int
+	}{
+		{header: `{"alg":"HS256","typ":"JWT"}`, payload: `{"sub":"1234567890","nested":{"a":1,"b":[{"c":1}]}}`, strict: true, status: 200},
+		{header: `{"alg":"HS256","typ":"JWT"}`, payload: `{"sub":"1234567890","sub":"admin"}`, strict: true, status: 401},
+		{header: `{"alg":"HS256","typ":"JWT"}`, payload: `{"nested":{"b":[{"c":1,"c":2}]}}`, strict: true, status: 401},
+		{header: `{"alg":"HS256","alg":"HS256"}`, payload: `{"sub":"1234567890"}`, strict: true, status: 401},
+		{header: `{"alg":"HS256","typ":"JWT"}`, payload: `{"sub":"1234567890","sub":"admin"}`, strict: false, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		signingInput := base64.RawURLEncoding.EncodeToString([]byte(tc.header)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(tc.payload))
+		signature, err := jwt.SigningMethodHS256.Sign(signingInput, []byte(defaultSigningKey))
+		utils.AssertEqual(t, nil, err)
+		token := signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+		var handlerErr error
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			StrictJSON: tc.strict,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				handlerErr = err
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.payload)
+		if tc.status != 200 {
+			utils.AssertEqual(t, true, errors.Is(handlerErr, jwtware.ErrJWTDuplicateKey))
+		}
+	}
+}
+
+func TestTokenTransform(t *testing.T) {
+	t.Parallel()
+
+	errUnknownVersion := errors.New("unknown token version")
+	cases := []struct {
+		prefix string
+		status int
+		err    error
+	}{
+		{prefix: "v2.", status: 200},
+		{prefix: "v1.", status: 401, err: errUnknownVersion},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var handlerErr error
+		config := jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: "header:X-Token:",
+			TokenTransform: func(raw string) (string, error) {
+				if !strings.HasPrefix(raw, "v2.") {
+					return "", errUnknownVersion
+				}
+				return strings.TrimPrefix(raw, "v2."), nil
+			},
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				handlerErr = err
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}
+		token, err := config.Sign(jwt.MapClaims{"sub": "1234567890"})
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			utils.AssertEqual(t, token, jwtware.RawTokenFromContext(c))
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("X-Token", tc.prefix+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+		if tc.err != nil {
+			utils.AssertEqual(t, true, errors.Is(handlerErr, tc.err))
+		}
+	}
+}
+
+func TestClaim(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	token := &jwt.Token{Claims: jwt.MapClaims{
+		"sub":    "1234567890",
+		"age":    float64(42),
+		"score":  1.5,
+		"big":    json.Number("9007199254740993"),
+		"exp":    float64(1516239022),
+		"aud":    "api",
+		"roles":  []interface{}{"admin", "user"},
+		"admin":  true,
+		"nested": map[string]interface{}{"a": "b"},
+	}}
+
+	// Act & Assert
+	sub, err := jwtware.Claim[string](token, "sub")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, "1234567890", sub)
+
+	age, err := jwtware.Claim[int](token, "age")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 42, age)
+
+	age32, err := jwtware.Claim[uint32](token, "age")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, uint32(42), age32)
+
+	score, err := jwtware.Claim[float64](token, "score")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 1.5, score)
+
+	_, err = jwtware.Claim[int](token, "score")
+	utils.AssertEqual(t, true, errors.Is(err, jwtware.ErrJWTClaimType))
+
+	big, err := jwtware.Claim[int64](token, "big")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, int64(9007199254740993), big)
+
+	_, err = jwtware.Claim[int32](token, "big")
+	utils.AssertEqual(t, true, errors.Is(err, jwtware.ErrJWTClaimType))
+
+	exp, err := jwtware.Claim[time.Time](token, "exp")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, int64(1516239022), exp.Unix())
+
+	date, err := jwtware.Claim[*jwt.NumericDate](token, "exp")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, int64(1516239022), date.Unix())
+
+	aud, err := jwtware.Claim[[]string](token, "aud")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, []string{"api"}, aud)
+
+	roles, err := jwtware.Claim[[]string](token, "roles")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, []string{"admin", "user"}, roles)
+
+	nested, err := jwtware.Claim[map[string]interface{}](token, "nested")
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, "b", nested["a"])
+
+	utils.AssertEqual(t, true, jwtware.MustClaim[bool](token, "admin"))
+
+	_, err = jwtware.Claim[string](token, "age")
+	utils.AssertEqual(t, true, errors.Is(err, jwtware.ErrJWTClaimType))
+
+	_, err = jwtware.Claim[string](token, "name")
+	utils.AssertEqual(t, true, errors.Is(err, jwtware.ErrJWTClaimMissing))
+
+	_, err = jwtware.Claim[string](nil, "sub")
+	utils.AssertEqual(t, true, errors.Is(err, jwtware.ErrJWTClaimMissing))
+
+	defer func() {
+		utils.AssertEqual(t, true, recover() != nil)
+	}()
+	jwtware.MustClaim[int](token, "name")
+}
+
+func TestMissingAndInvalidTokenResponse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		token  string
+		status int
+		body   string
+	}{
+		{name: "missing", token: "", status: 401, body: "please log in"},
+		{name: "invalid", token: "Bearer " + hamac[0].Token + "x", status: 403, body: "bad token"},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: hamac[0].SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			MissingTokenResponse: func(c *fiber.Ctx) error {
+				return c.Status(fiber.StatusUnauthorized).SendString("please log in")
+			},
+			InvalidTokenResponse: func(c *fiber.Ctx) error {
+				return c.Status(fiber.StatusForbidden).SendString("bad token")
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		if tc.token != "" {
+			req.Header.Add("Authorization", tc.token)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+		body, err := io.ReadAll(resp.Body)
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.body, string(body), tc.name)
+	}
+}
+
+func TestValidateClientIP(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		claims   jwt.MapClaims
+		clientIP string
+		status   int
+		err      error
+	}{
+		{claims: jwt.MapClaims{"cnf": map[string]interface{}{"ip": "203.0.113.7"}}, clientIP: "203.0.113.7", status: 200},
+		{claims: jwt.MapClaims{"cnf": map[string]interface{}{"ip": "::ffff:203.0.113.7"}}, clientIP: "203.0.113.7", status: 200},
+		{claims: jwt.MapClaims{"cnf": map[string]interface{}{"ip": "2001:0db8:0:0::1"}}, clientIP: "2001:db8::1", status: 200},
+		{claims: jwt.MapClaims{"cnf": map[string]interface{}{"ip": "203.0.113.7"}}, clientIP: "198.51.100.1", status: 401, err: jwtware.ErrJWTClientIPMismatch},
+		{claims: jwt.MapClaims{"cnf": map[string]interface{}{"ip": "not an ip"}}, clientIP: "203.0.113.7", status: 401, err: jwtware.ErrJWTClientIPMissing},
+		{claims: jwt.MapClaims{"sub": "1234567890"}, clientIP: "203.0.113.7", status: 401, err: jwtware.ErrJWTClientIPMissing},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var handlerErr error
+		config := jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			ValidateClientIP: true,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				handlerErr = err
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.Header.Add(fiber.HeaderXForwardedFor, tc.clientIP)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.clientIP)
+		if tc.err != nil {
+			utils.AssertEqual(t, true, errors.Is(handlerErr, tc.err))
+		}
+	}
+}
+
+func TestGoogleAndFirebaseConfig(t *testing.T) {
+	t.Parallel()
+
+	exp := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		name   string
+		config jwtware.Config
+		claims jwt.MapClaims
+		status int
+	}{
+		{name: "google", config: jwtware.GoogleConfig("client"), claims: jwt.MapClaims{"iss": "https://accounts.google.com", "aud": "client", "exp": exp}, status: 200},
+		{name: "google short issuer", config: jwtware.GoogleConfig("client"), claims: jwt.MapClaims{"iss": "accounts.google.com", "aud": "client", "exp": exp}, status: 200},
+		{name: "google other issuer", config: jwtware.GoogleConfig("client"), claims: jwt.MapClaims{"iss": "https://example.com", "aud": "client", "exp": exp}, status: 401},
+		{name: "google other audience", config: jwtware.GoogleConfig("client"), claims: jwt.MapClaims{"iss": "accounts.google.com", "aud": "other", "exp": exp}, status: 403},
+		{name: "google without expiry", config: jwtware.GoogleConfig("client"), claims: jwt.MapClaims{"iss": "accounts.google.com", "aud": "client"}, status: 403},
+		{name: "firebase", config: jwtware.FirebaseConfig("project"), claims: jwt.MapClaims{"iss": "https://securetoken.google.com/project", "aud": "project", "sub": "uid", "exp": exp}, status: 200},
+		{name: "firebase other project", config: jwtware.FirebaseConfig("project"), claims: jwt.MapClaims{"iss": "https://securetoken.google.com/other", "aud": "project", "sub": "uid", "exp": exp}, status: 401},
+		{name: "firebase without subject", config: jwtware.FirebaseConfig("project"), claims: jwt.MapClaims{"iss": "https://securetoken.google.com/project", "aud": "project", "exp": exp}, status: 403},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		utils.AssertEqual(t, 1, len(tc.config.JWKSetURLs), tc.name)
+		config := tc.config
+		config.JWKSetURLs = nil
+		config.SigningKey = jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestGoogleAndFirebaseConfigPanicWithoutAudience(t *testing.T) {
+	t.Parallel()
+
+	for _, build := range []func(string) jwtware.Config{jwtware.GoogleConfig, jwtware.FirebaseConfig} {
+		func() {
+			defer func() {
+				utils.AssertEqual(t, true, recover() != nil)
+			}()
+			build("")
+		}()
+	}
+}
+
+func TestExpiresIn(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	cases := []struct {
+		claims    jwt.MapClaims
+		emit      bool
+		expiresIn interface{}
+		header    string
+	}{
+		{claims: jwt.MapClaims{"exp": now.Add(90 * time.Second).Unix()}, emit: true, expiresIn: 90 * time.Second, header: "90"},
+		{claims: jwt.MapClaims{"exp": now.Add(90 * time.Second).Unix()}, emit: false, expiresIn: 90 * time.Second, header: ""},
+		{claims: jwt.MapClaims{"sub": "1234567890"}, emit: true, expiresIn: nil, header: ""},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		config := jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			TimeFunc:            func() time.Time { return now },
+			EmitExpiresInHeader: tc.emit,
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		var expiresIn interface{}
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			expiresIn = c.Locals(jwtware.ExpiresInContextKey)
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+		utils.AssertEqual(t, tc.expiresIn, expiresIn)
+		utils.AssertEqual(t, tc.header, resp.Header.Get("X-Token-Expires-In"))
+	}
+}
+
+func TestPropagateSubjectHeader(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		claims  jwt.MapClaims
+		spoofed string
+		subject string
+	}{
+		{claims: jwt.MapClaims{"sub": "1234567890"}, subject: "1234567890"},
+		{claims: jwt.MapClaims{"sub": "1234567890"}, spoofed: "admin", subject: "1234567890"},
+		{claims: jwt.MapClaims{"sub": "user\r\nSet-Cookie: a=b"}, subject: "userSet-Cookie: a=b"},
+		{claims: jwt.MapClaims{"name": "John"}, spoofed: "admin", subject: ""},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		config := jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			PropagateSubjectHeader: "X-Authenticated-Subject",
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		var requestHeader string
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			requestHeader = c.Get("X-Authenticated-Subject")
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		if tc.spoofed != "" {
+			req.Header.Add("X-Authenticated-Subject", tc.spoofed)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+		utils.AssertEqual(t, tc.subject, requestHeader)
+		utils.AssertEqual(t, tc.subject, resp.Header.Get("X-Authenticated-Subject"))
+		utils.AssertEqual(t, "", resp.Header.Get("Set-Cookie"))
+	}
+}
+
+func TestNamespacedClaim(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	token := &jwt.Token{Claims: jwt.MapClaims{
+		"https://myapp.com/roles": []interface{}{"admin"},
+		"roles":                   []interface{}{"bare"},
+		"email":                   "john@example.com",
+	}}
+
+	// Act & Assert
+	for _, namespace := range []string{"https://myapp.com/", "https://myapp.com"} {
+		roles, ok := jwtware.NamespacedClaim(token, namespace, "roles")
+		utils.AssertEqual(t, true, ok)
+		utils.AssertEqual(t, []interface{}{"admin"}, roles)
+	}
+
+	email, ok := jwtware.NamespacedClaim(token, "https://myapp.com/", "email")
+	utils.AssertEqual(t, true, ok)
+	utils.AssertEqual(t, "john@example.com", email)
+
+	_, ok = jwtware.NamespacedClaim(token, "https://myapp.com/", "tenant")
+	utils.AssertEqual(t, false, ok)
+
+	_, ok = jwtware.NamespacedClaim(nil, "https://myapp.com/", "roles")
+	utils.AssertEqual(t, false, ok)
+}
+
+func TestClaimsToLocalsWithNamespace(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+		ClaimsNamespace: "https://myapp.com/",
+		ClaimsToLocals: map[string]string{
+			"roles": "roles",
+			"sub":   "userID",
+		},
+	}
+	token, err := config.Sign(jwt.MapClaims{"sub": "1234567890", "https://myapp.com/roles": "admin"})
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(config))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		utils.AssertEqual(t, "admin", c.Locals("roles"))
+		utils.AssertEqual(t, "1234567890", c.Locals("userID"))
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 200, resp.StatusCode)
+}
+
+func TestContinueOnSuccess(t *testing.T) {
+	t.Parallel()
+
+	disabled := false
+	cases := []struct {
+		name    string
+		handler fiber.Handler
+		proceed *bool
+		// next is the handler after the middleware, if it does not send "OK".
+		next   fiber.Handler
+		status int
+		calls  int32
+	}{
+		{name: "returns nil", handler: func(c *fiber.Ctx) error { return nil }, status: 200, calls: 1},
+		{name: "calls next", handler: func(c *fiber.Ctx) error { return c.Next() }, status: 200, calls: 1},
+		{name: "calls next setting a header", handler: func(c *fiber.Ctx) error { return c.Next() }, next: func(c *fiber.Ctx) error {
+			c.Set("X-Handled", "true")
+			return nil
+		}, status: 200, calls: 1},
+		{name: "calls next doing nothing", handler: func(c *fiber.Ctx) error { return c.Next() }, next: func(c *fiber.Ctx) error { return nil }, status: 200, calls: 1},
+		{name: "writes response", handler: func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusTeapot) }, status: 418, calls: 0},
+		{name: "disabled", handler: func(c *fiber.Ctx) error { return nil }, proceed: &disabled, status: 200, calls: 0},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var calls int32
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: hamac[0].SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			SuccessHandler:    tc.handler,
+			ContinueOnSuccess: tc.proceed,
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			atomic.AddInt32(&calls, 1)
+			if tc.next != nil {
+				return tc.next(c)
+			}
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+hamac[0].Token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+		utils.AssertEqual(t, tc.calls, atomic.LoadInt32(&calls), tc.name)
+	}
+}
+
+func TestKeyDerivation(t *testing.T) {
+	t.Parallel()
+
+	// RFC 5869 test case 1.
+	master := bytes.Repeat([]byte{0x0b}, 22)
+	derivation := &jwtware.KeyDerivation{
+		Salt:   []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c},
+		Info:   []byte{0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8, 0xf9},
+		Length: 42,
+	}
+	derived, err := hex.DecodeString("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		name   string
+		key    jwtware.SigningKey
+		status int
+	}{
+		{name: "derived key", key: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: derived}, status: 200},
+		{name: "master secret", key: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: master}, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token, err := jwtware.Config{SigningKey: tc.key}.Sign(jwt.MapClaims{"sub": "1234567890"})
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: master, KeyDerivation: derivation},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+
+	// Signing with the derivation uses the derived key as well.
+	token, err := jwtware.Config{
+		SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: master, KeyDerivation: derivation},
+	}.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+	_, err = jwtware.Config{SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: derived}}.ParseAndValidate(token)
+	utils.AssertEqual(t, nil, err)
+}
+
+func TestKeyDerivationPanicsOnInvalidParameters(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		key  jwtware.SigningKey
+	}{
+		{name: "string secret", key: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: "secret", KeyDerivation: &jwtware.KeyDerivation{}}},
+		{name: "asymmetric algorithm", key: jwtware.SigningKey{JWTAlg: jwtware.RS256, Key: []byte("secret"), KeyDerivation: &jwtware.KeyDerivation{}}},
+		{name: "unknown hash", key: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte("secret"), KeyDerivation: &jwtware.KeyDerivation{Hash: "MD5"}}},
+		{name: "short key", key: jwtware.SigningKey{JWTAlg: jwtware.HS512, Key: []byte("secret"), KeyDerivation: &jwtware.KeyDerivation{Length: 32}}},
+	}
+
+	for _, tc := range cases {
+		func() {
+			defer func() {
+				utils.AssertEqual(t, true, recover() != nil, tc.name)
+			}()
+			jwtware.New(jwtware.Config{SigningKey: tc.key})
+		}()
+	}
+}
+
+func TestSigningKeyJWK(t *testing.T) {
+	t.Parallel()
+
+	ecKey, err := cryptoecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	ecJWK := fmt.Sprintf(`{"kty":"EC","crv":"P-256","alg":"ES256","x":"%s","y":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))))
+	ecToken, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "1234567890"}).SignedString(ecKey)
+	utils.AssertEqual(t, nil, err)
+
+	octJWK := fmt.Sprintf(`{"kty":"oct","k":"%s"}`, base64.RawURLEncoding.EncodeToString([]byte(defaultSigningKey)))
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"}).SignedString([]byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		name   string
+		config jwtware.Config
+		token  string
+	}{
+		{
+			name:   "EC JWK",
+			config: jwtware.Config{SigningKey: jwtware.SigningKey{JWK: json.RawMessage(ecJWK)}},
+			token:  ecToken,
+		},
+		{
+			name:   "oct JWK",
+			config: jwtware.Config{SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, JWK: json.RawMessage(octJWK)}},
+			token:  hmacToken,
+		},
+		{
+			name: "JWK in SigningKeys",
+			config: jwtware.Config{SigningKeys: map[string]jwtware.SigningKey{
+				"ec": {JWK: json.RawMessage(ecJWK)},
+			}},
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "1234567890"})
+				token.Header["kid"] = "ec"
+				signed, err := token.SignedString(ecKey)
+				utils.AssertEqual(t, nil, err)
+				return signed
+			}(),
+		},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+		app.Use(jwtware.New(tc.config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode, tc.name)
+	}
+}
+
+func TestSigningKeyJWKPanicsOnInvalidKey(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		key  jwtware.SigningKey
+	}{
+		{name: "malformed JSON", key: jwtware.SigningKey{JWK: json.RawMessage(`{"kty":`)}},
+		{name: "unsupported kty", key: jwtware.SigningKey{JWK: json.RawMessage(`{"kty":"XYZ"}`)}},
+		{name: "invalid parameters", key: jwtware.SigningKey{JWK: json.RawMessage(`{"kty":"EC","crv":"P-256","x":"AA","y":"AA"}`)}},
+		{name: "with Key", key: jwtware.SigningKey{Key: []byte("secret"), JWK: json.RawMessage(`{"kty":"oct","k":"c2VjcmV0"}`)}},
+	}
+
+	for _, tc := range cases {
+		func() {
+			defer func() {
+				utils.AssertEqual(t, true, recover() != nil, tc.name)
+			}()
+			jwtware.New(jwtware.Config{SigningKey: tc.key})
+		}()
+	}
+}
+
+func TestKeyFuncStopsWithRequestContext(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	release := make(chan struct{})
+	defer close(release)
+	var handlerErr error
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), 50*time.Millisecond)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	})
+	app.Use(jwtware.New(jwtware.Config{
+		KeyFunc: func(token *jwt.Token) (interface{}, error) {
+			// Blocks like a JWK Set refresh for an unknown "kid".
+			<-release
+			return []byte(defaultSigningKey), nil
+		},
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			handlerErr = err
+			return c.SendStatus(fiber.StatusUnauthorized)
+		},
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+hamac[0].Token)
+
+	// Act
+	resp, err := app.Test(req, 1000)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 401, resp.StatusCode)
+	utils.AssertEqual(t, true, errors.Is(handlerErr, context.DeadlineExceeded))
+}
+
+// loggerFunc adapts a function to jwtware.Logger.
+type loggerFunc func(format string, v ...interface{})
+
+func (f loggerFunc) Printf(format string, v ...interface{}) {
+	f(format, v...)
+}
+
+func TestFailOpenOnKeyUnavailable(t *testing.T) {
+	t.Parallel()
+
+	unknownKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+	unknown.Header["kid"] = "unknown"
+	unknownToken, err := unknown.SignedString(unknownKey)
+	utils.AssertEqual(t, nil, err)
+	parts := strings.Split(rsa[0].Token, ".")
+	badSignature := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("bad signature"))
+
+	cases := []struct {
+		name   string
+		outage bool
+		reject bool
+		replay bool
+		token  string
+		status int
+	}{
+		{name: "unknown kid during outage", outage: true, token: unknownToken, status: 200},
+		{name: "unknown kid during outage, rejected by BeforeNext", outage: true, reject: true, token: unknownToken, status: 401},
+		{name: "unknown kid during outage, without jti for ReplayCache", outage: true, replay: true, token: unknownToken, status: 403},
+		{name: "known kid during outage", outage: true, token: rsa[0].Token, status: 200},
+		{name: "bad signature during outage", outage: true, token: badSignature, status: 401},
+		{name: "unknown kid without outage", outage: false, token: unknownToken, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var failing int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(defaultKeySet))
+		}))
+		var warnings int32
+		config := jwtware.Config{
+			JWKSetURLs:               []string{server.URL},
+			FailOpenOnKeyUnavailable: true,
+			Logger: loggerFunc(func(format string, v ...interface{}) {
+				if strings.HasPrefix(format, "WARNING") {
+					atomic.AddInt32(&warnings, 1)
+				}
+			}),
+		}
+		if tc.reject {
+			config.BeforeNext = func(c *fiber.Ctx, token *jwt.Token) error {
+				return errors.New("rejected")
+			}
+		}
+		if tc.replay {
+			config.ReplayCache = jwtware.NewMemoryReplayCache(0)
+		}
+		middleware := jwtware.NewMiddleware(config)
+		if tc.outage {
+			atomic.StoreInt32(&failing, 1)
+		}
+
+		var unverified interface{}
+		app := fiber.New()
+		app.Use(middleware.Handler())
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			unverified = c.Locals(jwtware.UnverifiedContextKey)
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+		middleware.Close()
+		server.Close()
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+		failedOpen := tc.token == unknownToken && tc.outage
+		utils.AssertEqual(t, failedOpen && tc.status == 200, unverified == true, tc.name)
+		utils.AssertEqual(t, failedOpen, atomic.LoadInt32(&warnings) == 1, tc.name)
+	}
+}
+
+func TestDebugHMACFailures(t *testing.T) {
+	t.Parallel()
+
+	secret := "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0"
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	utils.AssertEqual(t, nil, err)
+	signedWithDecoded, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"}).SignedString(decoded)
+	utils.AssertEqual(t, nil, err)
+	signedWithSecret, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"}).SignedString([]byte(secret))
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		name  string
+		debug bool
+		token string
+		log   string
+	}{
+		{name: "valid", debug: true, token: signedWithSecret},
+		{name: "signature", debug: true, token: signedWithDecoded, log: "DEBUG: HS256 JWT verification failed on the signature, the configured secret has 36 bytes and may be base64 encoded"},
+		{name: "structure", debug: true, token: "a.b.c", log: "DEBUG: JWT verification failed on the token structure"},
+		{name: "disabled", token: signedWithDecoded},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var mu sync.Mutex
+		var logs []string
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey:        jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(secret)},
+			DebugHMACFailures: tc.debug,
+			Logger: loggerFunc(func(format string, v ...interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				logs = append(logs, fmt.Sprintf(format, v...))
+			}),
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		_, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		mu.Lock()
+		if tc.log == "" {
+			utils.AssertEqual(t, 0, len(logs), tc.name)
+		} else {
+			utils.AssertEqual(t, 1, len(logs), tc.name)
+			utils.AssertEqual(t, true, strings.HasPrefix(logs[0], tc.log), logs[0])
+			utils.AssertEqual(t, false, strings.Contains(logs[0], secret), tc.name)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestOnExpired(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{
+		JWTAlg: jwtware.HS256,
+		Key:    []byte(defaultSigningKey),
+	}
+	expired := time.Now().Add(-time.Hour).Unix()
+	future := time.Now().Add(time.Hour).Unix()
+
+	cases := []struct {
+		claims   jwt.MapClaims
+		key      []byte
+		accept   bool
+		status   int
+		notified bool
+	}{
+		{claims: jwt.MapClaims{"exp": expired}, accept: true, status: 200, notified: true},
+		{claims: jwt.MapClaims{"exp": expired}, accept: false, status: 401, notified: true},
+		{claims: jwt.MapClaims{"exp": expired}, key: []byte("other"), accept: true, status: 401},
+		{claims: jwt.MapClaims{"exp": expired, "nbf": future}, accept: true, status: 401},
+		{claims: jwt.MapClaims{"exp": future}, accept: false, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		key := signingKey
+		if tc.key != nil {
+			key.Key = tc.key
+		}
+		token, err := jwtware.Config{SigningKey: key}.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		notified := false
+		accept := tc.accept
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: signingKey,
+			OnExpired: func(c *fiber.Ctx, token *jwt.Token) error {
+				notified = true
+				if accept {
+					return nil
+				}
+				return errors.New("expired")
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			if _, ok := c.Locals("user").(*jwt.Token); !ok {
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+		utils.AssertEqual(t, tc.notified, notified)
+	}
+}
+
+func TestJwkDuplicateKID(t *testing.T) {
+	t.Parallel()
+
+	rsKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	psKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+
+	jwk := func(key *cryptorsa.PrivateKey, alg string) string {
+		return fmt.Sprintf(`{"kty":"RSA","kid":"shared","alg":"%s","e":"%s","n":"%s"}`,
+			alg,
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		)
+	}
+	keySet := `{"keys":[` + jwk(rsKey, jwtware.RS256) + "," + jwk(psKey, jwtware.PS256) + `]}`
+
+	sign := func(method jwt.SigningMethod, key *cryptorsa.PrivateKey) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = "shared"
+		signed, err := token.SignedString(key)
+		utils.AssertEqual(t, nil, err)
+		return signed
+	}
+
+	cases := []struct {
+		token  string
+		status int
+	}{
+		{token: sign(jwt.SigningMethodRS256, rsKey), status: 200},
+		{token: sign(jwt.SigningMethodPS256, psKey), status: 200},
+		{token: sign(jwt.SigningMethodRS256, psKey), status: 401},
+		{token: sign(jwt.SigningMethodRS384, rsKey), status: 401},
+	}
+
+	server := keySetServer(keySet)
+	defer server.Close()
+
+	for _, config := range []jwtware.Config{
+		{JWKSetJSON: []byte(keySet)},
+		{JWKSetURLs: []string{server.URL}},
+	} {
+		for _, tc := range cases {
+			// Arrange
+			middleware := jwtware.NewMiddleware(config)
+			app := fiber.New()
+			app.Use(middleware.Handler())
+			app.Get("/ok", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			req := httptest.NewRequest("GET", "/ok", nil)
+			req.Header.Add("Authorization", "Bearer "+tc.token)
+
+			// Act
+			resp, err := app.Test(req)
+			middleware.Close()
+
+			// Assert
+			utils.AssertEqual(t, nil, err)
+			utils.AssertEqual(t, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestJwkValidateOnStartup(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		keySet string
+		panics bool
+	}{
+		{keySet: `{"keys":[]}`, panics: true},
+		{keySet: `{"keys":[{"kty":"unknown","kid":"gofiber"}]}`, panics: true},
+		{keySet: defaultKeySet, panics: false},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		server := keySetServer(tc.keySet)
+
+		// Act
+		panicked := func() (panicked bool) {
+			defer func() {
+				panicked = recover() != nil
+			}()
+			jwtware.NewMiddleware(jwtware.Config{
+				JWKSetURLs:        []string{server.URL},
+				ValidateOnStartup: true,
+			}).Close()
+			return false
+		}()
+		server.Close()
+
+		// Assert
+		utils.AssertEqual(t, tc.panics, panicked)
+	}
+}
+
+func TestSigningKeyResolver(t *testing.T) {
+	t.Parallel()
+
+	secrets := map[string]string{
+		"tenant-a": "secret-a",
+		"tenant-b": "secret-b",
+	}
+	resolver := func(unverifiedClaims jwt.MapClaims) (jwtware.SigningKey, error) {
+		iss, _ := unverifiedClaims["iss"].(string)
+		secret, ok := secrets[iss]
+		if !ok {
+			return jwtware.SigningKey{}, errors.New("unknown issuer")
+		}
+		return jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(secret)}, nil
+	}
+
+	cases := []struct {
+		iss    string
+		secret string
+		status int
+	}{
+		{iss: "tenant-a", secret: "secret-a", status: 200},
+		{iss: "tenant-b", secret: "secret-b", status: 200},
+		{iss: "tenant-a", secret: "secret-b", status: 401},
+		{iss: "tenant-c", secret: "secret-c", status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token, err := jwtware.Config{
+			SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(tc.secret)},
+		}.Sign(jwt.MapClaims{"iss": tc.iss})
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKeyResolver: resolver,
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestParserOptions(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{
+		JWTAlg: jwtware.HS256,
+		Key:    []byte(defaultSigningKey),
+	}
+
+	cases := []struct {
+		claims jwt.MapClaims
+		status int
+	}{
+		{claims: jwt.MapClaims{"iss": "gofiber", "aud": "api"}, status: 200},
+		{claims: jwt.MapClaims{"iss": "other", "aud": "api"}, status: 401},
+		{claims: jwt.MapClaims{"iss": "gofiber"}, status: 403},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		config := jwtware.Config{
+			SigningKey: signingKey,
+			ParserOptions: []jwt.ParserOption{
+				jwt.WithIssuer("gofiber"),
+				jwt.WithAudience("api"),
+			},
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestRequireExpiration(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{
+		JWTAlg: jwtware.HS256,
+		Key:    []byte(defaultSigningKey),
+	}
+
+	cases := []struct {
+		claims  jwt.MapClaims
+		require bool
+		status  int
+	}{
+		{claims: jwt.MapClaims{"sub": "1234567890"}, require: true, status: 403},
+		{claims: jwt.MapClaims{"sub": "1234567890"}, require: false, status: 200},
+		{claims: jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}, require: true, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		config := jwtware.Config{
+			SigningKey:        signingKey,
+			RequireExpiration: tc.require,
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestDPoP(t *testing.T) {
+	t.Parallel()
+
+	proofKey, err := cryptoecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	jwk := jose.JSONWebKey{Key: &proofKey.PublicKey}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	utils.AssertEqual(t, nil, err)
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+		DPoP: true,
+	}
+	accessToken := func(jkt string) string {
+		token, err := config.Sign(jwt.MapClaims{"sub": "1234567890", "cnf": map[string]string{"jkt": jkt}})
+		utils.AssertEqual(t, nil, err)
+		return token
+	}
+	proof := func(accessToken, htm, jti string) string {
+		sum := sha256.Sum256([]byte(accessToken))
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"jti": jti,
+			"htm": htm,
+			"htu": "http://example.com/ok",
+			"iat": time.Now().Unix(),
+			"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+		})
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = jwk
+		signed, err := token.SignedString(proofKey)
+		utils.AssertEqual(t, nil, err)
+		return signed
+	}
+
+	bound := accessToken(jkt)
+	replayed := proof(bound, "GET", "replayed")
+
+	cases := []struct {
+		token string
+		proof string
+		err   error
+	}{
+		{token: bound, proof: proof(bound, "GET", "first")},
+		{token: bound, proof: "", err: jwtware.ErrDPoPProofMissing},
+		{token: bound, proof: replayed},
+		{token: bound, proof: replayed, err: jwtware.ErrDPoPProofReplayed},
+		{token: bound, proof: proof(bound, "POST", "method"), err: jwtware.ErrDPoPProofInvalid},
+		{token: bound, proof: proof(accessToken(jkt+"x"), "GET", "ath"), err: jwtware.ErrDPoPProofInvalid},
+		{token: accessToken("other"), proof: proof(accessToken("other"), "GET", "binding"), err: jwtware.ErrDPoPBindingMismatch},
+	}
+
+	// Arrange
+	var handlerErr error
+	config.ErrorHandler = func(c *fiber.Ctx, err error) error {
+		handlerErr = err
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+	app := fiber.New()
+	app.Use(jwtware.New(config))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for _, tc := range cases {
+		handlerErr = nil
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "DPoP "+tc.token)
+		if tc.proof != "" {
+			req.Header.Add("DPoP", tc.proof)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		if tc.err == nil {
+			utils.AssertEqual(t, 200, resp.StatusCode)
+		} else {
+			utils.AssertEqual(t, 401, resp.StatusCode)
+			utils.AssertEqual(t, true, errors.Is(handlerErr, tc.err))
+		}
+	}
+}
+
+// selfSignedCertificate returns a self-signed TLS certificate usable by servers and clients.
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := cryptoecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gofiber"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	utils.AssertEqual(t, nil, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertBinding(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	serverCert := selfSignedCertificate(t)
+	clientCert := selfSignedCertificate(t)
+	sum := sha256.Sum256(clientCert.Certificate[0])
+
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+		ValidateCertBinding: true,
+	}
+	bound, err := config.Sign(jwt.MapClaims{"cnf": map[string]string{"x5t#S256": base64.RawURLEncoding.EncodeToString(sum[:])}})
+	utils.AssertEqual(t, nil, err)
+	unbound, err := config.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(jwtware.New(config))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequestClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+	utils.AssertEqual(t, nil, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer func() {
+		_ = app.Shutdown()
+	}()
+
+	client := func(certificates ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates:       certificates,
+			InsecureSkipVerify: true, // The server certificate is self-signed.
+		}}}
+	}
+
+	cases := []struct {
+		client *http.Client
+		token  string
+		status int
+	}{
+		{client: client(clientCert), token: bound, status: 200},
+		{client: client(clientCert), token: unbound, status: 401},
+		{client: client(serverCert), token: bound, status: 401},
+		{client: client(), token: bound, status: 401},
+	}
+
+	for _, tc := range cases {
+		req, err := http.NewRequest("GET", "https://"+ln.Addr().String()+"/ok", nil)
+		utils.AssertEqual(t, nil, err)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := tc.client.Do(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+		_ = resp.Body.Close()
+	}
+
+	// Requests without TLS are rejected as well.
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer "+bound)
+	resp, err := app.Test(req)
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, 401, resp.StatusCode)
+}
+
+func TestTrustedGatewayHeader(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+
+	cases := []struct {
+		secret string
+		status int
+	}{
+		{secret: "gateway-secret", status: 200},
+		{secret: "wrong-secret", status: 401},
+		{secret: "", status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte("not the signing key"),
+			},
+			TrustedGatewayHeader: "X-Gateway-Secret",
+			TrustedGatewaySecret: "gateway-secret",
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			token, ok := c.Locals("user").(*jwt.Token)
+			utils.AssertEqual(t, true, ok)
+			utils.AssertEqual(t, "1234567890", token.Claims.(jwt.MapClaims)["sub"])
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+test.Token)
+		if tc.secret != "" {
+			req.Header.Add("X-Gateway-Secret", tc.secret)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{
+		JWTAlg: jwtware.HS256,
+		Key:    []byte(defaultSigningKey),
+	}
+	sign := func(key string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = "gofiber"
+		signed, err := token.SignedString([]byte(key))
+		utils.AssertEqual(t, nil, err)
+		return signed
+	}
+
+	errBeforeNext := errors.New("rejected before next")
+	valid := sign(defaultSigningKey, jwt.MapClaims{})
+
+	cases := []struct {
+		token string
+		// configure adjusts the configuration of the middleware, if set.
+		configure func(config *jwtware.Config)
+		stage     jwtware.AuthStage
+		kid       string
+		cause     error
+	}{
+		{token: "", stage: jwtware.StageExtraction, cause: jwtware.ErrJWTMissingOrMalformed},
+		{token: sign("other", jwt.MapClaims{}), stage: jwtware.StageParsing, kid: "gofiber", cause: jwt.ErrTokenSignatureInvalid},
+		{token: sign(defaultSigningKey, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), stage: jwtware.StageValidation, kid: "gofiber", cause: jwt.ErrTokenExpired},
+		{
+			token: valid,
+			configure: func(config *jwtware.Config) {
+				config.FailureLimiter = &testFailureLimiter{failures: map[string]int{"0.0.0.0": 2}}
+			},
+			stage: jwtware.StageExtraction,
+			cause: jwtware.ErrJWTTooManyFailures,
+		},
+		{
+			token: valid,
+			configure: func(config *jwtware.Config) {
+				config.AdditionalTokens = []jwtware.AdditionalToken{{Config: jwtware.Config{
+					SigningKey:  signingKey,
+					TokenLookup: "header:X-Additional",
+					ContextKey:  "additional",
+				}}}
+			},
+			stage: jwtware.StageExtraction,
+			cause: jwtware.ErrJWTMissingOrMalformed,
+		},
+		{
+			token: valid,
+			configure: func(config *jwtware.Config) {
+				config.BeforeNext = func(c *fiber.Ctx, token *jwt.Token) error {
+					return errBeforeNext
+				}
+			},
+			stage: jwtware.StageValidation,
+			kid:   "gofiber",
+			cause: errBeforeNext,
+		},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var authErr *jwtware.AuthError
+		config := jwtware.Config{
+			SigningKey: signingKey,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				utils.AssertEqual(t, true, errors.As(err, &authErr))
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}
+		if tc.configure != nil {
+			tc.configure(&config)
+		}
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		if tc.token != "" {
+			req.Header.Add("Authorization", "Bearer "+tc.token)
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 401, resp.StatusCode)
+		utils.AssertEqual(t, tc.stage, authErr.Stage)
+		utils.AssertEqual(t, tc.kid, authErr.KID)
+		utils.AssertEqual(t, true, errors.Is(authErr, tc.cause))
+		if tc.kid != "" {
+			utils.AssertEqual(t, jwtware.HS256, authErr.Alg)
+		}
+	}
+}
+
+func TestJwtFromSplitCookies(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	half := len(test.Token) / 2
+
+	cases := []struct {
+		parts  []string
+		status int
+	}{
+		{parts: []string{test.Token[:half], test.Token[half:]}, status: 200},
+		{parts: []string{test.Token}, status: 200},
+		{parts: []string{test.Token[:half]}, status: 401},
+		{parts: nil, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: "cookie-split:token",
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		for i, part := range tc.parts {
+			req.AddCookie(&http.Cookie{Name: fmt.Sprintf("token.%d", i), Value: part})
+		}
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestNewFromOptions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		claims jwt.MapClaims
+		status int
+	}{
+		{claims: jwt.MapClaims{"iss": "gofiber", "aud": "api"}, status: 200},
+		{claims: jwt.MapClaims{"iss": "gofiber", "aud": "other"}, status: 403},
+		{claims: jwt.MapClaims{"aud": "api"}, status: 403},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token, err := jwtware.Config{
+			SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		}.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.NewFromOptions(
+			jwtware.WithSigningKey(jwtware.HS256, []byte(defaultSigningKey)),
+			jwtware.WithIssuer("gofiber"),
+			jwtware.WithAudience("api"),
+			jwtware.WithLeeway(time.Minute),
+		))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestNewFromOptionsPanicsOnMultipleKeySources(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		// Assert
+		if err := recover(); err == nil {
+			t.Fatalf("Middleware should panic on mutually exclusive key sources")
+		}
+	}()
+
+	// Act
+	jwtware.NewFromOptions(
+		jwtware.WithSigningKey(jwtware.HS256, []byte(defaultSigningKey)),
+		jwtware.WithJWKS("https://example.com/.well-known/jwks.json"),
+	)
+}
+
+func TestValidateOIDCHashes(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	// Example from OpenID Connect Core 1.0 appendix A.3.
+	accessToken := "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"
+	code := "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk"
+	idToken := &jwt.Token{
+		Header: map[string]interface{}{"alg": jwtware.RS256},
+		Claims: jwt.MapClaims{
+			"at_hash": "77QmUPtjPfzWtF2AnpK9RQ",
+			"c_hash":  "LDktKdoQak3Pk0cnXxCltA",
+		},
+	}
+
+	// Act & Assert
+	utils.AssertEqual(t, nil, jwtware.ValidateAtHash(idToken, accessToken))
+	utils.AssertEqual(t, nil, jwtware.ValidateCHash(idToken, code))
+	utils.AssertEqual(t, jwtware.ErrJWTAtHash, jwtware.ValidateAtHash(idToken, code))
+	utils.AssertEqual(t, jwtware.ErrJWTCHash, jwtware.ValidateCHash(idToken, accessToken))
+
+	idToken.Claims = jwt.MapClaims{}
+	utils.AssertEqual(t, true, errors.Is(jwtware.ValidateAtHash(idToken, accessToken), jwt.ErrTokenRequiredClaimMissing))
+}
+
+func TestRequireSubject(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		claims jwt.MapClaims
+		status int
+	}{
+		{claims: jwt.MapClaims{"sub": "1234567890"}, status: 200},
+		{claims: jwt.MapClaims{"sub": ""}, status: 401},
+		{claims: jwt.MapClaims{"name": "John Doe"}, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		var handlerErr error
+		config := jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: jwtware.HS256,
+				Key:    []byte(defaultSigningKey),
+			},
+			RequireSubject: true,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				handlerErr = err
+				return c.SendStatus(fiber.StatusUnauthorized)
+			},
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+		if tc.status != 200 {
+			utils.AssertEqual(t, true, errors.Is(handlerErr, jwtware.ErrJWTMissingSubject))
+		}
+	}
+}
+
+func TestJwtFromProxyAuthorization(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+
+	cases := []struct {
+		header string
+		status int
+	}{
+		{header: "Bearer " + test.Token, status: 200},
+		{header: "bearer " + test.Token, status: 200},
+		{header: test.Token, status: 401},
+		{header: "Basic " + test.Token, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: "header:" + fiber.HeaderProxyAuthorization,
+			AuthScheme:  "Bearer",
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add(fiber.HeaderProxyAuthorization, tc.header)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestJwtFromCustomHeaderWithDefaultScheme(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+
+	cases := []struct {
+		lookup string
+		value  string
+		status int
+	}{
+		{lookup: "header:X-Auth", value: "Bearer " + test.Token, status: 200},
+		// Bare tokens in custom headers need the scheme to be disabled explicitly.
+		{lookup: "header:X-Auth", value: test.Token, status: 401},
+		{lookup: "header:X-Auth:", value: test.Token, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: tc.lookup,
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("X-Auth", tc.value)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.lookup)
+	}
+}
+
+func TestVerificationCache(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	var calls int32
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+	}
+	token, err := config.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		KeyFunc: func(token *jwt.Token) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return []byte(defaultSigningKey), nil
+		},
+		VerificationCacheTTL: time.Minute,
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	cases := []struct {
+		token  string
+		status int
+		calls  int32
+	}{
+		{token: token, status: 200, calls: 1},
+		{token: token, status: 200, calls: 1},
+		{token: forged, status: 401, calls: 2},
+		{token: token, status: 200, calls: 2},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+		utils.AssertEqual(t, tc.calls, atomic.LoadInt32(&calls))
+	}
+}
+
+func TestVerificationCacheCopiesClaims(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	token, err := jwtware.Config{
+		SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+	}.Sign(jwt.MapClaims{"sub": "1234567890", "roles": []string{"user"}})
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey:           jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		VerificationCacheTTL: time.Minute,
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+		seen := fmt.Sprint(claims["sub"], claims["roles"])
+		// Handlers commonly enrich the claims of the request.
+		claims["sub"] = "modified"
+		claims["roles"].([]interface{})[0] = "admin"
+		return c.SendString(seen)
+	})
+
+	// Act
+	var wg sync.WaitGroup
+	bodies := make(chan string, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/ok", nil)
+			req.Header.Add("Authorization", "Bearer "+token)
+			resp, err := app.Test(req)
+			if err != nil {
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			bodies <- string(body)
+		}()
+	}
+	wg.Wait()
+	close(bodies)
+
+	// Assert
+	count := 0
+	for body := range bodies {
+		utils.AssertEqual(t, "1234567890[user]", body)
+		count++
+	}
+	utils.AssertEqual(t, 20, count)
+}
+
+func TestCustomKeyfuncWithExpectedAudience(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		claims jwt.MapClaims
+		status int
+	}{
+		{claims: jwt.MapClaims{"aud": "api"}, status: 200},
+		{claims: jwt.MapClaims{"aud": []string{"web", "api"}}, status: 200},
+		{claims: jwt.MapClaims{"aud": "web"}, status: 403},
+		{claims: jwt.MapClaims{"sub": "1234567890"}, status: 403},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token, err := jwtware.Config{
+			SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		}.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			KeyFunc:          customKeyfunc(),
+			ExpectedAudience: "api",
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestIssuerPolicies(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		method jwt.SigningMethod
+		claims jwt.MapClaims
+		status int
+	}{
+		{name: "first issuer", method: jwt.SigningMethodHS256, claims: jwt.MapClaims{"iss": "https://a.example.com", "aud": "api"}, status: 200},
+		{name: "first issuer, other audience", method: jwt.SigningMethodHS256, claims: jwt.MapClaims{"iss": "https://a.example.com", "aud": "web"}, status: 403},
+		{name: "first issuer, other algorithm", method: jwt.SigningMethodHS384, claims: jwt.MapClaims{"iss": "https://a.example.com", "aud": "api"}, status: 401},
+		{name: "second issuer", method: jwt.SigningMethodHS384, claims: jwt.MapClaims{"iss": "https://b.example.com", "email": "john@example.com"}, status: 200},
+		{name: "second issuer, missing claim", method: jwt.SigningMethodHS256, claims: jwt.MapClaims{"iss": "https://b.example.com", "aud": "api"}, status: 403},
+		{name: "unlisted issuer", method: jwt.SigningMethodHS256, claims: jwt.MapClaims{"iss": "https://c.example.com", "aud": "api"}, status: 401},
+		{name: "no issuer", method: jwt.SigningMethodHS256, claims: jwt.MapClaims{"aud": "api"}, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token, err := jwt.NewWithClaims(tc.method, tc.claims).SignedString([]byte(defaultSigningKey))
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{Key: []byte(defaultSigningKey)},
+			IssuerPolicies: map[string]jwtware.IssuerPolicy{
+				"https://a.example.com": {Audience: "api", Algorithms: []string{jwtware.HS256}},
+				"https://b.example.com": {RequiredClaims: []string{"email"}},
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestIssuerPoliciesSigningKeys(t *testing.T) {
+	t.Parallel()
+
+	keyA, keyB := []byte("issuer-a-secret"), []byte("issuer-b-secret")
+	cases := []struct {
+		name   string
+		kid    string
+		key    []byte
+		iss    string
+		status int
+	}{
+		{name: "own key", kid: "a", key: keyA, iss: "https://a.example.com", status: 200},
+		{name: "other issuer", kid: "b", key: keyB, iss: "https://b.example.com", status: 200},
+		{name: "key of another issuer", kid: "a", key: keyA, iss: "https://b.example.com", status: 401},
+		{name: "shared key", kid: "c", key: []byte(defaultSigningKey), iss: "https://c.example.com", status: 200},
+		{name: "shared key, issuer with own keys", kid: "c", key: []byte(defaultSigningKey), iss: "https://a.example.com", status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": tc.iss})
+		token.Header["kid"] = tc.kid
+		signed, err := token.SignedString(tc.key)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKeys: map[string]jwtware.SigningKey{
+				"a": {JWTAlg: jwtware.HS256, Key: keyA},
+				"b": {JWTAlg: jwtware.HS256, Key: keyB},
+				"c": {JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+			},
+			IssuerPolicies: map[string]jwtware.IssuerPolicy{
+				"https://a.example.com": {SigningKeys: map[string]jwtware.SigningKey{"a": {JWTAlg: jwtware.HS256, Key: keyA}}},
+				"https://b.example.com": {SigningKeys: map[string]jwtware.SigningKey{"b": {JWTAlg: jwtware.HS256, Key: keyB}}},
+				"https://c.example.com": {},
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestIssuerPoliciesJWKSetURLs(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	keyA, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	keyB, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	serverA, serverB := keySetServer(rsaKeySet(keyA, "signing")), keySetServer(rsaKeySet(keyB, "signing"))
+	defer serverA.Close()
+	defer serverB.Close()
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		JWKSetURLs: []string{serverA.URL, serverB.URL},
+		IssuerPolicies: map[string]jwtware.IssuerPolicy{
+			"https://a.example.com": {JWKSetURLs: []string{serverA.URL}},
+			"https://b.example.com": {JWKSetURLs: []string{serverB.URL}},
+		},
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	cases := []struct {
+		name   string
+		key    *cryptorsa.PrivateKey
+		iss    string
+		status int
+	}{
+		{name: "first issuer", key: keyA, iss: "https://a.example.com", status: 200},
+		{name: "second issuer", key: keyB, iss: "https://b.example.com", status: 200},
+		{name: "key of another issuer", key: keyA, iss: "https://b.example.com", status: 401},
+	}
+
+	for _, tc := range cases {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": tc.iss})
+		token.Header["kid"] = "signing"
+		signed, err := token.SignedString(tc.key)
+		utils.AssertEqual(t, nil, err)
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signed)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestIssuerPoliciesUnknownJWKSetURL(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		utils.AssertEqual(t, true, recover() != nil)
+	}()
+	jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{Key: []byte(defaultSigningKey)},
+		IssuerPolicies: map[string]jwtware.IssuerPolicy{
+			"https://a.example.com": {JWKSetURLs: []string{"https://a.example.com/jwks.json"}},
+		},
+	})
+}
+
+func TestReplayCache(t *testing.T) {
+	t.Parallel()
+
+	exp := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		status []int
+	}{
+		{name: "single use", claims: jwt.MapClaims{"jti": "a", "exp": exp}, status: []int{200, 401}},
+		{name: "missing jti", claims: jwt.MapClaims{"exp": exp}, status: []int{403}},
+		{name: "missing exp", claims: jwt.MapClaims{"jti": "b"}, status: []int{403}},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tc.claims).SignedString([]byte(defaultSigningKey))
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey:  jwtware.SigningKey{Key: []byte(defaultSigningKey)},
+			ReplayCache: jwtware.NewMemoryReplayCache(0),
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		for _, status := range tc.status {
+			req := httptest.NewRequest("GET", "/ok", nil)
+			req.Header.Add("Authorization", "Bearer "+token)
+
+			// Act
+			resp, err := app.Test(req)
+
+			// Assert
+			utils.AssertEqual(t, nil, err)
+			utils.AssertEqual(t, status, resp.StatusCode, tc.name)
+		}
+	}
+}
+
+func TestReplayCacheAfterBeforeNext(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti": "d",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+
+	var calls int32
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey:  jwtware.SigningKey{Key: []byte(defaultSigningKey)},
+		ReplayCache: jwtware.NewMemoryReplayCache(0),
+		BeforeNext: func(c *fiber.Ctx, token *jwt.Token) error {
+			// The first request is rejected, e.g. by a failing authorization backend.
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return fiber.ErrForbidden
+			}
+			return nil
+		},
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	// The rejected request does not consume the token, so the retry is accepted once.
+	for _, status := range []int{403, 200, 401} {
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, status, resp.StatusCode)
+	}
+}
+
+func TestReplayCacheConcurrent(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti": "c",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey:  jwtware.SigningKey{Key: []byte(defaultSigningKey)},
+		ReplayCache: jwtware.NewMemoryReplayCache(0),
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	// Act
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/ok", nil)
+			req.Header.Add("Authorization", "Bearer "+token)
+			if resp, err := app.Test(req); err == nil && resp.StatusCode == fiber.StatusOK {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	utils.AssertEqual(t, int32(1), atomic.LoadInt32(&accepted))
+}
+
+func TestAuthorizedParty(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		claims   jwt.MapClaims
+		expected string
+		require  bool
+		status   int
+	}{
+		{name: "matching", claims: jwt.MapClaims{"aud": "api", "azp": "client"}, expected: "client", status: 200},
+		{name: "other party", claims: jwt.MapClaims{"aud": "api", "azp": "other"}, expected: "client", status: 403},
+		{name: "not a string", claims: jwt.MapClaims{"aud": "api", "azp": 1}, expected: "client", status: 403},
+		{name: "absent", claims: jwt.MapClaims{"aud": "api"}, expected: "client", status: 200},
+		{name: "absent with multiple audiences", claims: jwt.MapClaims{"aud": []string{"api", "web"}}, expected: "client", status: 200},
+		{name: "required with multiple audiences", claims: jwt.MapClaims{"aud": []string{"api", "web"}}, require: true, status: 403},
+		{name: "required with single audience", claims: jwt.MapClaims{"aud": []string{"api"}}, require: true, status: 200},
+		{name: "present with multiple audiences", claims: jwt.MapClaims{"aud": []string{"api", "web"}, "azp": "client"}, expected: "client", require: true, status: 200},
+		{name: "other party with multiple audiences", claims: jwt.MapClaims{"aud": []string{"api", "web"}, "azp": "web"}, expected: "client", require: true, status: 403},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		signingKey := jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)}
+		token, err := jwtware.Config{SigningKey: signingKey}.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		var authErr *jwtware.AuthError
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey:              signingKey,
+			ExpectedAudience:        "api",
+			ExpectedAuthorizedParty: tc.expected,
+			RequireAuthorizedPartyForMultipleAudiences: tc.require,
+			ErrorHandler: func(c *fiber.Ctx, err error) error {
+				errors.As(err, &authErr)
+				return c.SendStatus(jwtware.StatusCode(err))
+			},
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+		if tc.status != 200 {
+			utils.AssertEqual(t, jwtware.StageValidation, authErr.Stage, tc.name)
+		}
+	}
+}
+
+func TestMalformedAudience(t *testing.T) {
+	t.Parallel()
+
+	audiences := []interface{}{
+		[]interface{}{1, "api"},
+		[]interface{}{nil, "api"},
+		[]interface{}{"api", []interface{}{"api"}},
+		map[string]interface{}{"api": true},
+		1,
+	}
+	claimTypes := []struct {
+		name   string
+		claims jwt.Claims
+	}{
+		{name: "map", claims: jwt.MapClaims{}},
+		{name: "registered", claims: &jwt.RegisteredClaims{}},
+	}
+
+	for _, aud := range audiences {
+		// Arrange
+		token, err := jwtware.Config{
+			SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		}.Sign(jwt.MapClaims{"aud": aud, "sub": "1234567890"})
+		utils.AssertEqual(t, nil, err)
+
+		for _, claimType := range claimTypes {
+			app := fiber.New()
+			app.Use(jwtware.New(jwtware.Config{
+				SigningKey:       jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+				Claims:           claimType.claims,
+				ExpectedAudience: "api",
+			}))
+			app.Get("/ok", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			req := httptest.NewRequest("GET", "/ok", nil)
+			req.Header.Add("Authorization", "Bearer "+token)
+
+			// Act
+			resp, err := app.Test(req)
+
+			// Assert
+			utils.AssertEqual(t, nil, err)
+			utils.AssertEqual(t, 401, resp.StatusCode, fmt.Sprintf("%s %v", claimType.name, aud))
+		}
+
+		_, err = jwtware.Claim[[]string](&jwt.Token{Claims: jwt.MapClaims{"aud": aud}}, "aud")
+		utils.AssertEqual(t, true, errors.Is(err, jwtware.ErrJWTClaimType), fmt.Sprint(aud))
+	}
+}
+
+func TestDefaultErrorHandlerForbidden(t *testing.T) {
+	t.Parallel()
+
+	signingKey := jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)}
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		key    []byte
+		status int
+	}{
+		{name: "valid", claims: jwt.MapClaims{"aud": "api", "sub": "1234567890"}, status: 200},
+		{name: "other audience", claims: jwt.MapClaims{"aud": "web", "sub": "1234567890"}, status: 403},
+		{name: "missing subject", claims: jwt.MapClaims{"aud": "api"}, status: 403},
+		{name: "bad signature", claims: jwt.MapClaims{"aud": "web"}, key: []byte("other"), status: 401},
+		{name: "missing audience", claims: jwt.MapClaims{"sub": "1234567890"}, status: 403},
+		{name: "expired", claims: jwt.MapClaims{"aud": "api", "sub": "1234567890", "exp": 1}, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		key := signingKey
+		if tc.key != nil {
+			key.Key = tc.key
+		}
+		token, err := jwtware.Config{SigningKey: key}.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey:       signingKey,
+			ExpectedAudience: "api",
+			RequireSubject:   true,
+		}))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.name)
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{err: jwtware.ErrJWTMissingOrMalformed, status: fiber.StatusUnauthorized},
+		{err: jwtware.ErrJWTAlg, status: fiber.StatusUnauthorized},
+		{err: jwtware.ErrJWTMissingSubject, status: fiber.StatusForbidden},
+		{err: jwtware.ErrJWTTooManyFailures, status: fiber.StatusTooManyRequests},
+		{err: fmt.Errorf("wrapped: %w", jwtware.ErrJWTMissingSubject), status: fiber.StatusForbidden},
+		{err: &jwtware.AuthError{Stage: jwtware.StageValidation, Err: jwt.ErrTokenInvalidAudience}, status: fiber.StatusForbidden},
+		{err: jwt.ErrTokenRequiredClaimMissing, status: fiber.StatusForbidden},
+		{err: jwt.ErrTokenExpired, status: fiber.StatusUnauthorized},
+		{err: fiber.NewError(fiber.StatusPaymentRequired, "pay"), status: fiber.StatusPaymentRequired},
+		{err: errors.New("other"), status: fiber.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		// Act
+		status := jwtware.StatusCode(tc.err)
+
+		// Assert
+		utils.AssertEqual(t, tc.status, status, tc.err.Error())
+	}
+	var statusErr jwtware.StatusError
+	utils.AssertEqual(t, true, errors.As(jwtware.ErrJWTAlg, &statusErr))
+}
+
+func TestDefaultErrorHandlerStatusError(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	app := fiber.New()
+	app.Use(jwtware.New(jwtware.Config{
+		SigningKey: jwtware.SigningKey{JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		TokenTransform: func(raw string) (string, error) {
+			return "", fiber.NewError(fiber.StatusPaymentRequired)
+		},
+	}))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Add("Authorization", "Bearer token")
+
+	// Act
+	resp, err := app.Test(req)
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, fiber.StatusPaymentRequired, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, "Payment Required", string(body))
+}
+
+func TestJwtFromHeadersWithMixedSchemes(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+
+	cases := []struct {
+		header string
+		value  string
+		status int
+	}{
+		{header: "Authorization", value: "Bearer " + test.Token, status: 200},
+		{header: "Authorization", value: test.Token, status: 401},
+		{header: "X-Internal", value: test.Token, status: 200},
+		{header: "X-Service", value: "Token " + test.Token, status: 200},
+		{header: "X-Service", value: "Bearer " + test.Token, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: "header:Authorization:Bearer,header:X-Internal:,header:X-Service:Token",
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add(tc.header, tc.value)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestJwkPSS(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	keySet := rsaKeySet(privateKey, "gofiber-pss")
+	keySetWithAlg := strings.Replace(keySet, `"kty":"RSA"`, `"kty":"RSA","alg":"PS256"`, 1)
+
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodPS256, jwt.SigningMethodPS384, jwt.SigningMethodPS512} {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1234567890"})
+		token.Header["kid"] = "gofiber-pss"
+		signed, err := token.SignedString(privateKey)
+		utils.AssertEqual(t, nil, err)
+
+		for _, keySet := range []string{keySet, keySetWithAlg} {
+			server := keySetServer(keySet)
+
+			for _, config := range []jwtware.Config{
+				{JWKSetURLs: []string{server.URL}},
+				{JWKSetJSON: []byte(keySet)},
+			} {
+				// Arrange
+				middleware := jwtware.NewMiddleware(config)
+				app := fiber.New()
+				app.Use(middleware.Handler())
+				app.Get("/ok", func(c *fiber.Ctx) error {
+					return c.SendString("OK")
+				})
+
+				req := httptest.NewRequest("GET", "/ok", nil)
+				req.Header.Add("Authorization", "Bearer "+signed)
+
+				// Act
+				resp, err := app.Test(req)
+				middleware.Close()
+
+				// Assert
+				utils.AssertEqual(t, nil, err)
+				if keySet == keySetWithAlg && method != jwt.SigningMethodPS256 {
+					// The JWK is restricted to PS256.
+					utils.AssertEqual(t, 401, resp.StatusCode)
+				} else {
+					utils.AssertEqual(t, 200, resp.StatusCode)
+				}
+			}
+			server.Close()
+		}
+	}
+}
+
+func TestConfigDebug(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := jwtware.Config{
+		SigningKeys: map[string]jwtware.SigningKey{
+			"gofiber": {JWTAlg: jwtware.HS256, Key: []byte(defaultSigningKey)},
+		},
+	}
+	sign := func(kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString([]byte(defaultSigningKey))
+		utils.AssertEqual(t, nil, err)
+		return signed
+	}
+
+	// Act
+	valid := config.Debug(sign("gofiber", jwt.MapClaims{"sub": "1234567890"}))
+	expired := config.Debug(sign("gofiber", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}))
+	unknown := config.Debug(sign("unknown", jwt.MapClaims{}))
+	malformed := config.Debug("not a token")
+
+	// Assert
+	utils.AssertEqual(t, nil, valid.Error)
+	utils.AssertEqual(t, "SigningKeys", valid.KeyPath)
+	utils.AssertEqual(t, true, valid.KIDKnown)
+	utils.AssertEqual(t, "gofiber", valid.Header["kid"])
+
+	utils.AssertEqual(t, true, errors.Is(expired.Error, jwt.ErrTokenExpired))
+	utils.AssertEqual(t, true, expired.KIDKnown)
+
+	utils.AssertEqual(t, false, unknown.KIDKnown)
+	utils.AssertEqual(t, true, unknown.Error != nil)
+
+	utils.AssertEqual(t, true, errors.Is(malformed.Error, jwt.ErrTokenMalformed))
+	utils.AssertEqual(t, true, malformed.Header == nil)
+}
+
+func TestConfigDebugJWK(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{JWK: json.RawMessage(`{"kty":"oct","alg":"HS256","k":"` +
+			base64.RawURLEncoding.EncodeToString([]byte(defaultSigningKey)) + `"}`)},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"}).SignedString([]byte(defaultSigningKey))
+	utils.AssertEqual(t, nil, err)
+
+	// Act
+	result := config.Debug(token)
+
+	// Assert
+	utils.AssertEqual(t, nil, result.Error)
+	utils.AssertEqual(t, "SigningKey.JWK", result.KeyPath)
+}
+
+func TestJwkSignedKeySet(t *testing.T) {
+	t.Parallel()
+
+	anchorKey, err := cryptoecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	otherKey, err := cryptoecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	utils.AssertEqual(t, nil, err)
+	privateKey, err := cryptorsa.GenerateKey(rand.Reader, 2048)
+	utils.AssertEqual(t, nil, err)
+	keySet := rsaKeySet(privateKey, "gofiber-signed")
+
+	signKeySet := func(key *cryptoecdsa.PrivateKey) string {
+		var claims jwt.MapClaims
+		utils.AssertEqual(t, nil, json.Unmarshal([]byte(keySet), &claims))
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+		utils.AssertEqual(t, nil, err)
+		return signed
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "1234567890"})
+	token.Header["kid"] = "gofiber-signed"
+	signedToken, err := token.SignedString(privateKey)
+	utils.AssertEqual(t, nil, err)
+
+	cases := []struct {
+		body   string
+		panics bool
+	}{
+		{body: signKeySet(anchorKey), panics: false},
+		{body: signKeySet(otherKey), panics: true},
+		{body: keySet, panics: true},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		server := keySetServer(tc.body)
+		var middleware *jwtware.Middleware
+		panicked := func() (panicked bool) {
+			defer func() {
+				panicked = recover() != nil
+			}()
+			middleware = jwtware.NewMiddleware(jwtware.Config{
+				JWKSetURLs:               []string{server.URL},
+				VerifyDiscoverySignature: true,
+				DiscoveryTrustAnchor:     jwtware.SigningKey{JWTAlg: jwtware.ES256, Key: &anchorKey.PublicKey},
+			})
+			return false
+		}()
+		utils.AssertEqual(t, tc.panics, panicked)
+		if panicked {
+			server.Close()
+			continue
+		}
+
+		app := fiber.New()
+		app.Use(middleware.Handler())
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+signedToken)
+
+		// Act
+		resp, err := app.Test(req)
+		middleware.Close()
+		server.Close()
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+	}
+}
+
+func TestTimeFunc(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	signingKey := jwtware.SigningKey{
+		JWTAlg: jwtware.HS256,
+		Key:    []byte(defaultSigningKey),
+	}
+
+	cases := []struct {
+		claims jwt.MapClaims
+		clock  time.Time
+		leeway time.Duration
+		status int
+	}{
+		{claims: jwt.MapClaims{"exp": now.Unix()}, clock: now.Add(-time.Minute), status: 200},
+		{claims: jwt.MapClaims{"exp": now.Unix()}, clock: now.Add(time.Minute), status: 401},
+		{claims: jwt.MapClaims{"exp": now.Unix()}, clock: now.Add(time.Minute), leeway: 2 * time.Minute, status: 200},
+		{claims: jwt.MapClaims{"nbf": now.Unix()}, clock: now.Add(-time.Minute), status: 401},
+		{claims: jwt.MapClaims{"iat": now.Unix()}, clock: now.Add(-time.Minute), status: 401},
+		{claims: jwt.MapClaims{"iat": now.Unix()}, clock: now, status: 200},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		clock := tc.clock
+		config := jwtware.Config{
+			SigningKey:         signingKey,
+			Leeway:             tc.leeway,
+			RejectFutureIssued: true,
+			TimeFunc: func() time.Time {
+				return clock
+			},
+		}
+		token, err := config.Sign(tc.claims)
+		utils.AssertEqual(t, nil, err)
+
+		app := fiber.New()
+		app.Use(jwtware.New(config))
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestParseAndValidate(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	config := jwtware.Config{
+		SigningKey: jwtware.SigningKey{
+			JWTAlg: jwtware.HS256,
+			Key:    []byte(defaultSigningKey),
+		},
+		RequireSubject: true,
+	}
+	valid, err := config.Sign(jwt.MapClaims{"sub": "1234567890"})
+	utils.AssertEqual(t, nil, err)
+	invalid, err := config.Sign(jwt.MapClaims{"name": "John Doe"})
+	utils.AssertEqual(t, nil, err)
+	middleware := jwtware.NewMiddleware(config)
+	defer middleware.Close()
+
+	// Act
+	token, err := config.ParseAndValidate(valid)
+	reused, reusedErr := middleware.ParseAndValidate(valid)
+	_, invalidErr := middleware.ParseAndValidate(invalid)
+	_, malformedErr := middleware.ParseAndValidate("not a token")
+
+	// Assert
+	utils.AssertEqual(t, nil, err)
+	utils.AssertEqual(t, "1234567890", token.Claims.(jwt.MapClaims)["sub"])
+	utils.AssertEqual(t, nil, reusedErr)
+	utils.AssertEqual(t, true, reused.Valid)
+	utils.AssertEqual(t, jwtware.ErrJWTMissingSubject, invalidErr)
+	utils.AssertEqual(t, true, errors.Is(malformedErr, jwt.ErrTokenMalformed))
+}
+
+func TestNestedJWT(t *testing.T) {
+	t.Parallel()
+
+	key := []byte(defaultSigningKey)
+	inner, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1234567890"}).SignedString(key)
+	utils.AssertEqual(t, nil, err)
+	inner384, err := jwt.NewWithClaims(jwt.SigningMethodHS384, jwt.MapClaims{"sub": "1234567890"}).SignedString(key)
+	utils.AssertEqual(t, nil, err)
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}).SignedString(key)
+	utils.AssertEqual(t, nil, err)
+	wrap := func(payload string, key []byte) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","cty":"JWT"}`))
+		signingInput := header + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+		signature, err := jwt.SigningMethodHS256.Sign(signingInput, key)
+		utils.AssertEqual(t, nil, err)
+		return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+
+	cases := []struct {
+		token    string
+		maxDepth int
+		methods  []string
+		status   int
+	}{
+		{token: wrap(inner, key), status: 200},
+		{token: wrap(inner, []byte("other")), status: 401},
+		{token: wrap(expired, key), status: 401},
+		{token: wrap(wrap(inner, key), key), status: 401},
+		{token: wrap(wrap(inner, key), key), maxDepth: 2, status: 200},
+		{token: wrap(inner, key), maxDepth: -1, status: 401},
+		{token: wrap(inner384, key), methods: []string{jwtware.HS256, jwtware.HS384}, status: 200},
+		{token: wrap(inner384, key), methods: []string{jwtware.HS384}, status: 401},
+	}
+
+	for _, tc := range cases {
+		// Arrange
+		app := fiber.New()
+
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey:      jwtware.SigningKey{Key: key},
+			MaxNestingDepth: tc.maxDepth,
+			ParserOptions:   []jwt.ParserOption{jwt.WithValidMethods(tc.methods)},
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			sub, err := c.Locals("user").(*jwt.Token).Claims.GetSubject()
+			if err != nil {
+				return err
+			}
+			return c.SendString(sub)
+		})
+
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.Header.Add("Authorization", "Bearer "+tc.token)
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, tc.status, resp.StatusCode, tc.token)
+		if tc.status == 200 {
+			body, err := io.ReadAll(resp.Body)
+			utils.AssertEqual(t, nil, err)
+			utils.AssertEqual(t, "1234567890", string(body))
+		}
+	}
+}
+
+func TestJwtFromEncryptedCookie(t *testing.T) {
+	t.Parallel()
+
+	test := hamac[0]
+	key := encryptcookie.GenerateKey()
+	encrypted, err := encryptcookie.EncryptCookie(test.Token, key)
+	utils.AssertEqual(t, nil, err)
+
+	for _, lookup := range []string{"cookie:token", "cookie-split:token"} {
+		// Arrange
+		app := fiber.New()
+
+		// The encryptcookie middleware must run first, so that the JWT middleware reads the decrypted value.
+		app.Use(encryptcookie.New(encryptcookie.Config{Key: key}))
+		app.Use(jwtware.New(jwtware.Config{
+			SigningKey: jwtware.SigningKey{
+				JWTAlg: test.SigningMethod,
+				Key:    []byte(defaultSigningKey),
+			},
+			TokenLookup: lookup,
+		}))
+
+		app.Get("/ok", func(c *fiber.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		name := "token"
+		if lookup == "cookie-split:token" {
+			name = "token.0"
+		}
+		req := httptest.NewRequest("GET", "/ok", nil)
+		req.AddCookie(&http.Cookie{Name: name, Value: encrypted})
+
+		// Act
+		resp, err := app.Test(req)
+
+		// Assert
+		utils.AssertEqual(t, nil, err)
+		utils.AssertEqual(t, 200, resp.StatusCode)
+	}
+}
@@ -0,0 +1,72 @@
+package jwtware
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultMaxNestingDepth = 1
+
+var (
+	// ErrJWTNesting is returned when a nested JWT ("cty" header "JWT") is malformed or nested too deeply.
+	ErrJWTNesting = newStatusError(fiber.StatusUnauthorized, "invalid nested JWT")
+)
+
+// nestedHeader returns the decoded header of the compact token if its "cty" header announces
+// a nested JWT (RFC 7519 section 5.2).
+func nestedHeader(token string, unmarshal utils.JSONUnmarshal) (map[string]interface{}, bool) {
+	header, ok := decodeHeader(token, unmarshal)
+	if !ok {
+		return nil, false
+	}
+	cty, _ := header["cty"].(string)
+	return header, strings.EqualFold(cty, "JWT")
+}
+
+// verifyNested verifies the signature of a compact JWT whose payload is another JWT, and then verifies the
+// inner token with the same configuration. The inner token is returned, as it carries the claims.
+func (v *verifier) verifyNested(ctx context.Context, signed string, header map[string]interface{}, depth int) (*jwt.Token, error) {
+	if depth >= v.cfg.MaxNestingDepth {
+		return nil, fmt.Errorf("%w: more than %d levels of nesting", ErrJWTNesting, v.cfg.MaxNestingDepth)
+	}
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected three segments", ErrJWTNesting)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode payload", ErrJWTNesting)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode signature", ErrJWTNesting)
+	}
+
+	alg, _ := header["alg"].(string)
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrJWTNesting, alg)
+	}
+	if err = v.checkMethod(alg); err != nil {
+		return nil, err
+	}
+	key, err := v.keyFunc(ctx)(&jwt.Token{Raw: signed, Header: header, Method: method})
+	if err != nil {
+		return nil, err
+	}
+	if err = method.Verify(parts[0]+"."+parts[1], signature, key); err != nil {
+		return nil, fmt.Errorf("%w: %s", jwt.ErrTokenSignatureInvalid, err)
+	}
+
+	inner, err := v.signedJWT(string(payload))
+	if err != nil {
+		return nil, err
+	}
+	return v.verifyDepth(ctx, inner, depth+1)
+}
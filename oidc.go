@@ -0,0 +1,92 @@
+package jwtware
+
+import (
+	"crypto"
+	_ "crypto/sha256" // Registers the hash functions used by hashForAlg.
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWTAtHash is returned when the "at_hash" claim of an ID token does not match the access token.
+	ErrJWTAtHash = newStatusError(fiber.StatusUnauthorized, "the ID token at_hash claim does not match the access token")
+
+	// ErrJWTCHash is returned when the "c_hash" claim of an ID token does not match the authorization code.
+	ErrJWTCHash = newStatusError(fiber.StatusUnauthorized, "the ID token c_hash claim does not match the authorization code")
+
+	// ErrJWTAuthorizedParty is returned when the "azp" claim does not match ExpectedAuthorizedParty.
+	ErrJWTAuthorizedParty = newStatusError(fiber.StatusForbidden, "the JWT azp claim does not match the expected authorized party")
+)
+
+// ValidateAtHash checks the "at_hash" claim of the given OpenID Connect ID token against the access token
+// issued along with it (OpenID Connect Core 1.0 section 3.2.2.9).
+func ValidateAtHash(idToken *jwt.Token, accessToken string) error {
+	return validateOIDCHash(idToken, "at_hash", accessToken, ErrJWTAtHash)
+}
+
+// ValidateCHash checks the "c_hash" claim of the given OpenID Connect ID token against the authorization
+// code issued along with it (OpenID Connect Core 1.0 section 3.3.2.11).
+func ValidateCHash(idToken *jwt.Token, code string) error {
+	return validateOIDCHash(idToken, "c_hash", code, ErrJWTCHash)
+}
+
+// validateAuthorizedParty checks the "azp" claim against ExpectedAuthorizedParty and, for tokens with several
+// audiences, requires it if RequireAuthorizedPartyForMultipleAudiences is set.
+func (cfg *Config) validateAuthorizedParty(claims jwt.Claims) error {
+	azp, present := claimsMap(claims, cfg.JSONUnmarshal)["azp"]
+	if !present {
+		if cfg.RequireAuthorizedPartyForMultipleAudiences {
+			if aud, err := claims.GetAudience(); err == nil && len(aud) > 1 {
+				return fmt.Errorf("%w: azp claim is required for multiple audiences", jwt.ErrTokenRequiredClaimMissing)
+			}
+		}
+		return nil
+	}
+	party, ok := azp.(string)
+	if !ok || (cfg.ExpectedAuthorizedParty != "" && subtle.ConstantTimeCompare([]byte(party), []byte(cfg.ExpectedAuthorizedParty)) != 1) {
+		return ErrJWTAuthorizedParty
+	}
+	return nil
+}
+
+// validateOIDCHash compares the named claim with the base64url encoded left half of the hash of value,
+// using the hash function of the "alg" header of the token.
+func validateOIDCHash(idToken *jwt.Token, claim, value string, mismatch error) error {
+	expected, ok := claimValue(idToken.Claims, claim).(string)
+	if !ok {
+		return fmt.Errorf("%w: %s claim is required", jwt.ErrTokenRequiredClaimMissing, claim)
+	}
+	alg, _ := idToken.Header["alg"].(string)
+	hash, err := hashForAlg(alg)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	if base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]) != expected {
+		return mismatch
+	}
+	return nil
+}
+
+// hashForAlg returns the hash function of the given JWT algorithm. EdDSA tokens use SHA-512, as Ed25519 does.
+func hashForAlg(alg string) (crypto.Hash, error) {
+	switch {
+	case alg == jwt.SigningMethodEdDSA.Alg():
+		return crypto.SHA512, nil
+	case strings.HasSuffix(alg, "256"), alg == ES256K:
+		return crypto.SHA256, nil
+	case strings.HasSuffix(alg, "384"):
+		return crypto.SHA384, nil
+	case strings.HasSuffix(alg, "512"):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("%w: no hash function known for %q", ErrJWTAlg, alg)
+}
@@ -0,0 +1,146 @@
+package jwtware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Option configures the middleware created by NewFromOptions.
+type Option func(*options)
+
+// options collects the configuration built by the Options, along with the key sources they set,
+// which are mutually exclusive.
+type options struct {
+	cfg        Config
+	keySources []string
+}
+
+func (o *options) keySource(name string) {
+	o.keySources = append(o.keySources, name)
+}
+
+// NewFromOptions returns the middleware handler configured by the given options. It panics if more than
+// one key source (WithSigningKey, WithSigningKeys, WithJWKS, WithJWKSetJSON, WithKeyFunc) is given.
+// Options cover the common settings; use New with a Config for all others.
+func NewFromOptions(opts ...Option) fiber.Handler {
+	return New(ConfigFromOptions(opts...))
+}
+
+// ConfigFromOptions returns the Config built by the given options, e.g. to complement it before calling New.
+// It panics like NewFromOptions.
+func ConfigFromOptions(opts ...Option) Config {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.keySources) > 1 {
+		panic("Fiber: JWT middleware configuration: The key sources " + strings.Join(o.keySources, ", ") + " are mutually exclusive.")
+	}
+	return o.cfg
+}
+
+// WithSigningKey verifies tokens with the given key, which must have been used with the given algorithm.
+func WithSigningKey(alg string, key interface{}) Option {
+	return func(o *options) {
+		o.keySource("WithSigningKey")
+		o.cfg.SigningKey = SigningKey{JWTAlg: alg, Key: key}
+	}
+}
+
+// WithSigningKeys verifies tokens with the key matching their "kid" header.
+func WithSigningKeys(keys map[string]SigningKey) Option {
+	return func(o *options) {
+		o.keySource("WithSigningKeys")
+		o.cfg.SigningKeys = keys
+	}
+}
+
+// WithJWKS verifies tokens with the keys of the JWK Sets at the given URLs.
+func WithJWKS(urls ...string) Option {
+	return func(o *options) {
+		o.keySource("WithJWKS")
+		o.cfg.JWKSetURLs = urls
+	}
+}
+
+// WithJWKSetJSON verifies tokens with the keys of the given JWK Set.
+func WithJWKSetJSON(keySet []byte) Option {
+	return func(o *options) {
+		o.keySource("WithJWKSetJSON")
+		o.cfg.JWKSetJSON = keySet
+	}
+}
+
+// WithKeyFunc verifies tokens with the key returned by the given function.
+func WithKeyFunc(keyFunc jwt.Keyfunc) Option {
+	return func(o *options) {
+		o.keySource("WithKeyFunc")
+		o.cfg.KeyFunc = keyFunc
+	}
+}
+
+// WithLeeway allows the given clock skew when validating time based claims.
+func WithLeeway(leeway time.Duration) Option {
+	return func(o *options) {
+		o.cfg.Leeway = leeway
+	}
+}
+
+// WithAudience requires the "aud" claim to contain the given audience.
+func WithAudience(aud string) Option {
+	return func(o *options) {
+		o.cfg.ParserOptions = append(o.cfg.ParserOptions, jwt.WithAudience(aud))
+	}
+}
+
+// WithIssuer requires the "iss" claim to be the given issuer.
+func WithIssuer(iss string) Option {
+	return func(o *options) {
+		o.cfg.ParserOptions = append(o.cfg.ParserOptions, jwt.WithIssuer(iss))
+	}
+}
+
+// WithClaims parses the claims of tokens into the given type, see Config.Claims.
+func WithClaims(claims jwt.Claims) Option {
+	return func(o *options) {
+		o.cfg.Claims = claims
+	}
+}
+
+// WithTokenLookup sets where tokens are looked up, see Config.TokenLookup.
+func WithTokenLookup(lookup string) Option {
+	return func(o *options) {
+		o.cfg.TokenLookup = lookup
+	}
+}
+
+// WithContextKey sets the context key the token is stored under.
+func WithContextKey(key string) Option {
+	return func(o *options) {
+		o.cfg.ContextKey = key
+	}
+}
+
+// WithFilter skips the middleware for requests the given function returns true for.
+func WithFilter(filter func(*fiber.Ctx) bool) Option {
+	return func(o *options) {
+		o.cfg.Filter = filter
+	}
+}
+
+// WithSuccessHandler sets the handler executed for a valid token.
+func WithSuccessHandler(handler fiber.Handler) Option {
+	return func(o *options) {
+		o.cfg.SuccessHandler = handler
+	}
+}
+
+// WithErrorHandler sets the handler executed for an invalid token.
+func WithErrorHandler(handler fiber.ErrorHandler) Option {
+	return func(o *options) {
+		o.cfg.ErrorHandler = handler
+	}
+}
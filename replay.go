@@ -0,0 +1,89 @@
+package jwtware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrJWTReplayed is returned when ReplayCache is set and the "jti" of the JWT was already used.
+var ErrJWTReplayed = newStatusError(fiber.StatusUnauthorized, "the JWT was already used")
+
+// ReplayCache records the "jti" claims of used tokens for Config.ReplayCache. Implementations may keep them in
+// memory, see NewMemoryReplayCache, or in a store shared by several instances such as Redis.
+type ReplayCache interface {
+	// Add records the jti for the given duration and reports whether it was added, i.e. was not recorded
+	// yet. It must check and record atomically, e.g. with SET NX PX in Redis, as exactly-once use under
+	// concurrent requests relies on it.
+	Add(jti string, ttl time.Duration) (bool, error)
+	// Exists reports whether the jti is recorded. It only lets replays be rejected without writing.
+	Exists(jti string) (bool, error)
+}
+
+// checkReplay records the "jti" of the token until it expires, rejecting tokens whose "jti" was already used.
+func (cfg *Config) checkReplay(token *jwt.Token) error {
+	jti, _ := claimsMap(token.Claims, cfg.JSONUnmarshal)["jti"].(string)
+	if jti == "" {
+		return fmt.Errorf("%w: jti claim is required for replay protection", jwt.ErrTokenRequiredClaimMissing)
+	}
+	exp, err := token.Claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return fmt.Errorf("%w: exp claim is required for replay protection", jwt.ErrTokenRequiredClaimMissing)
+	}
+	// The token is accepted until its expiry plus Leeway, and at least briefly if OnExpired accepted it.
+	ttl := exp.Sub(cfg.TimeFunc()) + cfg.Leeway
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	if used, err := cfg.ReplayCache.Exists(jti); err != nil {
+		return fmt.Errorf("failed to look up the JWT in the replay cache: %w", err)
+	} else if used {
+		return ErrJWTReplayed
+	}
+	added, err := cfg.ReplayCache.Add(jti, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to record the JWT in the replay cache: %w", err)
+	}
+	if !added {
+		return ErrJWTReplayed
+	}
+	return nil
+}
+
+// defaultMemoryReplayCacheSize is the number of "jti" claims NewMemoryReplayCache holds by default.
+const defaultMemoryReplayCacheSize = 100000
+
+// memoryReplayCache is a ReplayCache in the memory of the process.
+type memoryReplayCache struct {
+	now func() time.Time
+
+	mux  sync.Mutex
+	jtis *jtiSet
+}
+
+// NewMemoryReplayCache returns a ReplayCache keeping the "jti" claims in memory, which suits a single
+// instance. It holds at most size of them, or 100000 if size is not positive. Expired ones are dropped as new
+// ones are added. If all are still valid, the one expiring first is dropped, and its token could be replayed
+// from then on, so size should exceed the number of tokens accepted within their lifetime.
+func NewMemoryReplayCache(size int) ReplayCache {
+	if size <= 0 {
+		size = defaultMemoryReplayCacheSize
+	}
+	return &memoryReplayCache{now: time.Now, jtis: newJTISet(size)}
+}
+
+func (m *memoryReplayCache) Add(jti string, ttl time.Duration) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	now := m.now()
+	return m.jtis.add(jti, now.Add(ttl), now), nil
+}
+
+func (m *memoryReplayCache) Exists(jti string) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.jtis.contains(jti, m.now()), nil
+}
@@ -0,0 +1,90 @@
+package jwtware
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultScopeClaim     = "scope"
+	defaultScopeDelimiter = " "
+)
+
+// RequireScopes returns a handler which only continues the chain if the token stored by the middleware
+// grants all given scopes, and responds with 403 otherwise. It reads the scopes from the space delimited
+// "scope" claim of the token stored under "user". Use Middleware.RequireScopes for other configurations.
+func RequireScopes(scopes ...string) fiber.Handler {
+	return requireScopes(defaultContextKey, defaultScopeClaim, defaultScopeDelimiter, scopes)
+}
+
+// RequireScopes returns a handler which only continues the chain if the token stored by the middleware
+// grants all given scopes, and responds with 403 otherwise. It honors ContextKey, ScopeClaim and ScopeDelimiter.
+func (m *Middleware) RequireScopes(scopes ...string) fiber.Handler {
+	return requireScopes(m.cfg.ContextKey, m.cfg.ScopeClaim, m.cfg.ScopeDelimiter, scopes)
+}
+
+func requireScopes(contextKey, claim, delimiter string, scopes []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, ok := c.Locals(contextKey).(*jwt.Token)
+		if !ok {
+			return sendError(c, fiber.StatusUnauthorized, "invalid_token", "Invalid or expired JWT")
+		}
+		granted := make(map[string]struct{})
+		for _, scope := range tokenScopes(token.Claims, claim, delimiter) {
+			granted[scope] = struct{}{}
+		}
+		for _, scope := range scopes {
+			if _, ok := granted[scope]; !ok {
+				return sendError(c, fiber.StatusForbidden, "insufficient_scope", "Insufficient scope")
+			}
+		}
+		return c.Next()
+	}
+}
+
+// tokenScopes reads the scopes from the given claim, which may either be a delimited string
+// or an array of strings.
+func tokenScopes(claims jwt.Claims, claim, delimiter string) []string {
+	switch value := claimValue(claims, claim).(type) {
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool {
+			return strings.ContainsRune(delimiter, r)
+		})
+	case []interface{}:
+		scopes := make([]string, 0, len(value))
+		for _, v := range value {
+			if scope, ok := v.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes
+	case []string:
+		return value
+	}
+	return nil
+}
+
+// claimValue returns the value of the named claim.
+func claimValue(claims jwt.Claims, name string) interface{} {
+	return claimsMap(claims, json.Unmarshal)[name]
+}
+
+// claimsMap returns the claims as jwt.MapClaims. Claims other than jwt.MapClaims are read through
+// their JSON representation, which is decoded with the given function.
+func claimsMap(claims jwt.Claims, unmarshal utils.JSONUnmarshal) jwt.MapClaims {
+	mapClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		raw, err := json.Marshal(claims)
+		if err != nil {
+			return nil
+		}
+		if err = unmarshal(raw, &mapClaims); err != nil {
+			return nil
+		}
+	}
+	return mapClaims
+}
@@ -0,0 +1,139 @@
+package jwtware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	dcrecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningMethodES256K is the ES256K signing method of RFC 8812: ECDSA on the secp256k1 curve with SHA-256.
+// Signatures are created and verified with github.com/decred/dcrd/dcrec/secp256k1. Keys are *ecdsa.PublicKey
+// and *ecdsa.PrivateKey on Secp256k1Curve.
+//
+// The method is not registered with github.com/golang-jwt/jwt by importing the package, as the registry is
+// global. It is registered by RegisterES256K, which is called for configurations using ES256K keys: keys with
+// JWTAlg ES256K and secp256k1 JWKs, including those of fetched JWK Sets.
+var SigningMethodES256K jwt.SigningMethod = signingMethodES256K{}
+
+var registerES256KOnce sync.Once
+
+// RegisterES256K registers SigningMethodES256K with github.com/golang-jwt/jwt, so that tokens with the
+// ES256K algorithm can be parsed. It only needs to be called for keys the middleware does not see, e.g. those
+// returned by a custom KeyFunc.
+func RegisterES256K() {
+	registerES256KOnce.Do(func() {
+		jwt.RegisterSigningMethod(ES256K, func() jwt.SigningMethod {
+			return SigningMethodES256K
+		})
+	})
+}
+
+// Secp256k1Curve returns the secp256k1 curve used by ES256K, e.g. to generate keys with ecdsa.GenerateKey.
+// Neither the standard library nor github.com/golang-jwt/jwt provide it, so the one of
+// github.com/decred/dcrd/dcrec/secp256k1 is returned.
+func Secp256k1Curve() elliptic.Curve {
+	return secp256k1.S256()
+}
+
+type signingMethodES256K struct{}
+
+func (signingMethodES256K) Alg() string {
+	return ES256K
+}
+
+// Verify checks the R || S signature of the signing string with the given *ecdsa.PublicKey.
+func (signingMethodES256K) Verify(signingString string, sig []byte, key interface{}) error {
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || ecdsaKey.Curve.Params().Name != Secp256k1 {
+		return jwt.ErrInvalidKeyType
+	}
+	publicKey, err := secp256k1PublicKey(ecdsaKey)
+	if err != nil {
+		return err
+	}
+	if len(sig) != 64 {
+		return jwt.ErrECDSAVerification
+	}
+	var r, s secp256k1.ModNScalar
+	// Values not below the order of the curve overflow and are rejected rather than reduced.
+	if r.SetByteSlice(sig[:32]) || s.SetByteSlice(sig[32:]) {
+		return jwt.ErrECDSAVerification
+	}
+	hash := sha256.Sum256([]byte(signingString))
+	if !dcrecdsa.NewSignature(&r, &s).Verify(hash[:], publicKey) {
+		return jwt.ErrECDSAVerification
+	}
+	return nil
+}
+
+// Sign returns the R || S signature of the signing string with the given *ecdsa.PrivateKey. The nonce is
+// derived deterministically as described in RFC 6979.
+func (signingMethodES256K) Sign(signingString string, key interface{}) ([]byte, error) {
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecdsaKey.Curve.Params().Name != Secp256k1 {
+		return nil, jwt.ErrInvalidKeyType
+	}
+	privateKey := secp256k1.PrivKeyFromBytes(ecdsaKey.D.FillBytes(make([]byte, 32)))
+	defer privateKey.Zero()
+	hash := sha256.Sum256([]byte(signingString))
+	sig := dcrecdsa.Sign(privateKey, hash[:])
+	r, s := sig.R(), sig.S()
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	return append(rBytes[:], sBytes[:]...), nil
+}
+
+// secp256k1PublicKey converts the given key, rejecting points which are not on the curve.
+func secp256k1PublicKey(key *ecdsa.PublicKey) (*secp256k1.PublicKey, error) {
+	if key.X == nil || key.Y == nil || key.X.BitLen() > 256 || key.Y.BitLen() > 256 {
+		return nil, fmt.Errorf("%w: the point is not on the curve", ErrJWK)
+	}
+	serialized := make([]byte, 65)
+	serialized[0] = 0x04
+	key.X.FillBytes(serialized[1:33])
+	key.Y.FillBytes(serialized[33:])
+	publicKey, err := secp256k1.ParsePubKey(serialized)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJWK, err)
+	}
+	return publicKey, nil
+}
+
+// secp256k1JWK is the subset of a JWK needed to parse secp256k1 keys, which the JWK library skips.
+type secp256k1JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	Algorithm string `json:"alg"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+}
+
+// parseSecp256k1JWK parses an EC JWK on the secp256k1 curve, rejecting points which are not on the curve.
+func parseSecp256k1JWK(jwk secp256k1JWK) (*ecdsa.PublicKey, error) {
+	if jwk.KeyType != "EC" || jwk.Curve != Secp256k1 {
+		return nil, fmt.Errorf("%w: not a %s key", ErrJWK, Secp256k1)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJWK, err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJWK, err)
+	}
+	key := &ecdsa.PublicKey{Curve: Secp256k1Curve(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if _, err := secp256k1PublicKey(key); err != nil {
+		return nil, err
+	}
+	RegisterES256K()
+	return key, nil
+}
@@ -0,0 +1,90 @@
+package jwtware
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MicahParks/keyfunc/v2"
+)
+
+// sharedJWKSets holds the JWK Sets of JWKSetURLs shared by configurations with the same URLs and refresh
+// settings, so that mounting the middleware on several routes fetches and refreshes each JWK Set once.
+var sharedJWKSets = struct {
+	mux     sync.Mutex
+	entries map[string]*sharedJWKSEntry
+}{entries: make(map[string]*sharedJWKSEntry)}
+
+type sharedJWKSEntry struct {
+	jwks  *keyfunc.MultipleJWKS
+	index *keySetIndex
+	refs  int
+}
+
+// jwksShareKey returns the key the JWK Sets of the configuration are shared under, or false if they must
+// not be shared, e.g. because the configuration holds functions or given keys which cannot be compared.
+func (cfg *Config) jwksShareKey(givenKeys map[string]keyfunc.GivenKey) (string, bool) {
+	if cfg.JWKSetIsolated || len(givenKeys) > 0 || cfg.JWKSetKeySelector != nil || cfg.OnJWKSRefresh != nil ||
+		cfg.VerifyDiscoverySignature || cfg.FailOpenOnKeyUnavailable {
+		return "", false
+	}
+	urls := append([]string(nil), cfg.JWKSetURLs...)
+	sort.Strings(urls)
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%t|%t|%t|%t|%p", strings.Join(urls, " "), cfg.JWKSetRefreshInterval,
+		cfg.JWKSetRefreshJitter, cfg.JWKSetRefreshRateLimit, cfg.JWKSetRefreshTimeout, *cfg.JWKSetRefreshUnknownKID,
+		cfg.JWKSetNoBackgroundRefresh, cfg.JWKSetAllowMissingKID, cfg.JWKSetStreaming, cfg.JWKSetHTTPClient), true
+}
+
+// acquireJWKS returns the JWK Sets of JWKSetURLs and their index, shared with other configurations if
+// possible, along with the function releasing them. The background refreshes stop once every configuration
+// released them.
+func (cfg *Config) acquireJWKS(givenKeys map[string]keyfunc.GivenKey) (*keyfunc.MultipleJWKS, *keySetIndex, func(), error) {
+	key, ok := cfg.jwksShareKey(givenKeys)
+	if !ok {
+		index := newKeySetIndex(cfg.JSONUnmarshal)
+		jwks, err := multiKeyfunc(givenKeys, *cfg, index)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return jwks, index, onceFunc(func() { endBackground(jwks) }), nil
+	}
+
+	sharedJWKSets.mux.Lock()
+	defer sharedJWKSets.mux.Unlock()
+	entry, ok := sharedJWKSets.entries[key]
+	if !ok {
+		index := newKeySetIndex(cfg.JSONUnmarshal)
+		jwks, err := multiKeyfunc(givenKeys, *cfg, index)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		entry = &sharedJWKSEntry{jwks: jwks, index: index}
+		sharedJWKSets.entries[key] = entry
+	}
+	entry.refs++
+	return entry.jwks, entry.index, onceFunc(func() {
+		sharedJWKSets.mux.Lock()
+		defer sharedJWKSets.mux.Unlock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(sharedJWKSets.entries, key)
+			endBackground(entry.jwks)
+		}
+	}), nil
+}
+
+// endBackground stops the background refreshes of the given JWK Sets.
+func endBackground(multiJWKS *keyfunc.MultipleJWKS) {
+	for _, jwks := range multiJWKS.JWKSets() {
+		jwks.EndBackground()
+	}
+}
+
+// onceFunc returns a function calling f on its first call only.
+func onceFunc(f func()) func() {
+	var once sync.Once
+	return func() {
+		once.Do(f)
+	}
+}
@@ -0,0 +1,31 @@
+package jwtware
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWTSigningKey is returned by Config.Sign when no SigningKey with a JWTAlg is configured.
+	ErrJWTSigningKey = errors.New("signing requires SigningKey with Key and JWTAlg")
+)
+
+// Sign returns a compact JWT with the given claims, signed with SigningKey.Key using SigningKey.JWTAlg,
+// so that it is accepted by a middleware using the same configuration. It fails for configurations
+// without SigningKey, such as JWKS-only ones, and for public keys, which cannot sign.
+func (cfg Config) Sign(claims jwt.Claims) (string, error) {
+	key, err := cfg.SigningKey.resolve()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrJWTSigningKey, err)
+	}
+	if key.Key == nil || key.JWTAlg == "" {
+		return "", ErrJWTSigningKey
+	}
+	method := jwt.GetSigningMethod(key.JWTAlg)
+	if method == nil {
+		return "", fmt.Errorf("%w: unknown algorithm %q", ErrJWTSigningKey, key.JWTAlg)
+	}
+	return jwt.NewWithClaims(method, claims).SignedString(key.Key)
+}
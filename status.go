@@ -0,0 +1,52 @@
+package jwtware
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// StatusError is implemented by the errors of the middleware to suggest the HTTP status code of the
+// response, like *fiber.Error does. The default ErrorHandler responds with the suggested status code.
+type StatusError interface {
+	error
+	StatusCode() int
+}
+
+// StatusCode returns the HTTP status code suggested for an error passed to ErrorHandler: the code of the first
+// StatusError or *fiber.Error in its chain, 403 Forbidden for the claim errors of github.com/golang-jwt/jwt
+// about a token not meant for this resource, and 401 Unauthorized otherwise.
+func StatusCode(err error) int {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	if errors.Is(err, jwt.ErrTokenInvalidAudience) || errors.Is(err, jwt.ErrTokenRequiredClaimMissing) {
+		return fiber.StatusForbidden
+	}
+	return fiber.StatusUnauthorized
+}
+
+// statusError is an error of the middleware with the HTTP status code suggested for the response.
+type statusError struct {
+	status  int
+	message string
+}
+
+// newStatusError returns an error with the given message, suggesting the given HTTP status code.
+func newStatusError(status int, message string) error {
+	return &statusError{status: status, message: message}
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func (e *statusError) StatusCode() int {
+	return e.status
+}
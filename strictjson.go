@@ -0,0 +1,94 @@
+package jwtware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+var (
+	// ErrJWTDuplicateKey is returned when StrictJSON is set and the JWT header or payload contains an object
+	// with a duplicate key.
+	ErrJWTDuplicateKey = newStatusError(fiber.StatusUnauthorized, "the JWT contains duplicate JSON keys")
+)
+
+// checkDuplicateKeys rejects compact tokens whose header or payload contains duplicate object keys, which
+// different JSON parsers resolve differently. Malformed tokens are left to the parser to reject.
+func checkDuplicateKeys(signed string, unmarshal utils.JSONUnmarshal) error {
+	first, last := strings.IndexByte(signed, '.'), strings.LastIndexByte(signed, '.')
+	if first < 0 || first == last {
+		return nil
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(signed[:first])
+	if err != nil {
+		return nil
+	}
+	if hasDuplicateKeys(rawHeader) {
+		return fmt.Errorf("%w in the header", ErrJWTDuplicateKey)
+	}
+
+	var header struct {
+		B64 *bool  `json:"b64"`
+		Cty string `json:"cty"`
+	}
+	if err = unmarshal(rawHeader, &header); err != nil || strings.EqualFold(header.Cty, "JWT") {
+		// The payload of a nested JWT is a token, which is checked when it is verified.
+		return nil
+	}
+	payload := []byte(signed[first+1 : last])
+	if header.B64 == nil || *header.B64 {
+		if payload, err = base64.RawURLEncoding.DecodeString(string(payload)); err != nil {
+			return nil
+		}
+	}
+	if hasDuplicateKeys(payload) {
+		return fmt.Errorf("%w in the payload", ErrJWTDuplicateKey)
+	}
+	return nil
+}
+
+// hasDuplicateKeys reports whether the given JSON contains an object with a duplicate key, at any depth.
+func hasDuplicateKeys(data []byte) bool {
+	dup, _ := walkDuplicateKeys(json.NewDecoder(bytes.NewReader(data)))
+	return dup
+}
+
+func walkDuplicateKeys(dec *json.Decoder) (bool, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	switch token {
+	case json.Delim('{'):
+		keys := make(map[string]struct{})
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return false, err
+			}
+			name, _ := key.(string)
+			if _, ok := keys[name]; ok {
+				return true, nil
+			}
+			keys[name] = struct{}{}
+			if dup, err := walkDuplicateKeys(dec); dup || err != nil {
+				return dup, err
+			}
+		}
+	case json.Delim('['):
+		for dec.More() {
+			if dup, err := walkDuplicateKeys(dec); dup || err != nil {
+				return dup, err
+			}
+		}
+	default:
+		return false, nil
+	}
+	_, err = dec.Token()
+	return false, err
+}
@@ -0,0 +1,108 @@
+package jwtware
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrJWTUnencodedPayload is returned when a JWT with an unencoded payload (RFC 7797) is malformed.
+	ErrJWTUnencodedPayload = newStatusError(fiber.StatusUnauthorized, "the JWT has an unencoded payload")
+)
+
+// unencodedHeader returns the decoded header of the compact token if it sets "b64" to false (RFC 7797).
+// The header is only inspected if AllowUnencodedPayload is set, otherwise such tokens fail verification.
+func (v *verifier) unencodedHeader(token string) (map[string]interface{}, bool) {
+	if !v.cfg.AllowUnencodedPayload {
+		return nil, false
+	}
+	header, ok := decodeHeader(token, v.cfg.JSONUnmarshal)
+	if !ok {
+		return nil, false
+	}
+	b64, ok := header["b64"].(bool)
+	return header, ok && !b64
+}
+
+// decodeHeader returns the header of the compact token, decoded with the given function, without verifying it.
+func decodeHeader(token string, unmarshal utils.JSONUnmarshal) (map[string]interface{}, bool) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return nil, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, false
+	}
+	var header map[string]interface{}
+	if err = unmarshal(raw, &header); err != nil {
+		return nil, false
+	}
+	return header, true
+}
+
+// verifyUnencoded verifies a compact JWT with an unencoded payload (RFC 7797), whose signing input is the
+// encoded header followed by the raw payload. jwt.Parser does not support such tokens, so the signature is
+// verified here, checking the algorithm like the parser, and the claims are then validated by parsing an
+// unsigned token carrying the same payload with the claims parser.
+func (v *verifier) verifyUnencoded(ctx context.Context, signed string, header map[string]interface{}) (*jwt.Token, error) {
+	if !critContains(header, "b64") {
+		return nil, fmt.Errorf(`%w: "b64" must be listed in the "crit" header`, ErrJWTUnencodedPayload)
+	}
+	first, last := strings.IndexByte(signed, '.'), strings.LastIndexByte(signed, '.')
+	if first == last {
+		return nil, fmt.Errorf("%w: expected three segments", ErrJWTUnencodedPayload)
+	}
+	signingInput, payload := signed[:last], signed[first+1:last]
+	signature, err := base64.RawURLEncoding.DecodeString(signed[last+1:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode signature", ErrJWTUnencodedPayload)
+	}
+
+	alg, _ := header["alg"].(string)
+	method := jwt.GetSigningMethod(alg)
+	if method == nil || method == jwt.SigningMethodNone {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrJWTUnencodedPayload, alg)
+	}
+	if err = v.checkMethod(alg); err != nil {
+		return nil, err
+	}
+	key, err := v.keyFunc(ctx)(&jwt.Token{Raw: signed, Header: header, Method: method})
+	if err != nil {
+		return nil, err
+	}
+	if err = method.Verify(signingInput, signature, key); err != nil {
+		return nil, fmt.Errorf("%w: %s", jwt.ErrTokenSignatureInvalid, err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(payload)) + "."
+	token, err := v.claimsParser.ParseWithClaims(unsigned, v.newClaims(), func(*jwt.Token) (interface{}, error) {
+		return jwt.UnsafeAllowNoneSignatureType, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	token.Raw = signed
+	token.Header = header
+	token.Method = method
+	token.Signature = signature
+	return token, nil
+}
+
+// critContains reports whether the "crit" header lists the given header parameter.
+func critContains(header map[string]interface{}, name string) bool {
+	crit, _ := header["crit"].([]interface{})
+	for _, c := range crit {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}